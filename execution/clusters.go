@@ -0,0 +1,107 @@
+package execution
+
+import (
+	"context"
+
+	"github.com/gprossliner/kustomizepb/knownerror"
+	"github.com/gprossliner/kustomizepb/kubeaccess"
+	"github.com/gprossliner/kustomizepb/playbook"
+)
+
+// clusterAccess returns the access to a cluster of the clusters map. The KubeAccess is
+// created and the identity of the cluster is verified when the cluster is used for the first time,
+// clusters with an identity are already used by verifyClusters.
+func (options *Options) clusterAccess(ctx context.Context, name string) (*playbook.ClusterAccess, error) {
+	cluster, ok := options.Clusters[name]
+	if !ok {
+		return nil, knownerror.NewKnownError("Cluster '%s' is not defined", name)
+	}
+
+	ca, err := options.newClusterAccess(name, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	if cluster.Identity != nil && !options.verifiedClusters[name] {
+		if err := cluster.Identity.Verify(ctx, ca.KubeAccess); err != nil {
+			return nil, knownerror.NewKnownError("Cluster '%s': %s", name, err)
+		}
+
+		if options.verifiedClusters == nil {
+			options.verifiedClusters = map[string]bool{}
+		}
+		options.verifiedClusters[name] = true
+	}
+
+	return ca, nil
+}
+
+// verifyClusters verifies the identity of all clusters used by the playbook which have one,
+// before anything touches the clusters
+func (options *Options) verifyClusters(ctx context.Context, pb *playbook.Playbook) error {
+	for _, name := range pb.ReferencedClusters() {
+		if cluster, ok := options.Clusters[name]; ok && cluster.Identity != nil {
+			if _, err := options.clusterAccess(ctx, name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// newClusterAccess returns the access to the cluster, the KubeAccess is shared by clusters with the same context
+func (options *Options) newClusterAccess(name string, cluster playbook.Cluster) (*playbook.ClusterAccess, error) {
+
+	ca := &playbook.ClusterAccess{KubeConfig: cluster.KubeConfig, KubeContext: cluster.Context}
+	if ca.KubeConfig == "" {
+		ca.KubeConfig = options.KubeConfig
+	}
+	if ca.KubeContext == "" {
+		ca.KubeContext = options.KubeContext
+	}
+
+	if ca.KubeConfig == options.KubeConfig && ca.KubeContext == options.KubeContext {
+		ca.KubeAccess = options.KubeAccess
+		return ca, nil
+	}
+
+	key := ca.KubeConfig + "\x00" + ca.KubeContext
+	if ka, ok := options.kubeAccesses[key]; ok {
+		ca.KubeAccess = ka
+		return ca, nil
+	}
+
+	ka, err := kubeaccess.NewKubeAccess(ca.KubeConfig, ca.KubeContext)
+	if err != nil {
+		return nil, knownerror.NewKnownError("Unable to access cluster '%s': %s", name, err)
+	}
+
+	if options.kubeAccesses == nil {
+		options.kubeAccesses = map[string]*kubeaccess.KubeAccess{}
+	}
+	options.kubeAccesses[key] = ka
+	ca.KubeAccess = ka
+
+	return ca, nil
+}
+
+// resolveClusters sets the clusters of the playbook, with the contexts overridden by ClusterContexts
+func (options *Options) resolveClusters(pb *playbook.Playbook) error {
+	options.Clusters = map[string]playbook.Cluster{}
+	for name, cluster := range pb.Clusters {
+		options.Clusters[name] = cluster
+	}
+
+	for name, kubecontext := range options.ClusterContexts {
+		cluster, ok := options.Clusters[name]
+		if !ok {
+			return knownerror.NewKnownError("Cluster '%s' is not defined in the playbook", name)
+		}
+
+		cluster.Context = kubecontext
+		options.Clusters[name] = cluster
+	}
+
+	return nil
+}
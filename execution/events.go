@@ -0,0 +1,203 @@
+package execution
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gprossliner/kustomizepb/playbook"
+)
+
+type EventID int
+
+const (
+	EV_ComponentStarted EventID = iota
+	EV_TestApplyConditions
+	EV_ApplyConditionsNotFulfilled
+	EV_ComponentApplying
+	EV_ComponentApplyRetry
+	EV_TestReadiness
+	EV_ComponentReady
+	EV_ComponentApplied
+	EV_ReadinessNotFulfilled
+	EV_ComponentSkipped
+	EV_ComponentFailed
+	EV_ConditionEvaluated
+	EV_RunFinished
+)
+
+// eventNames are the names of the events, like in the JSON output
+var eventNames = map[EventID]string{
+	EV_ComponentStarted:            "componentStarted",
+	EV_TestApplyConditions:         "testApplyConditions",
+	EV_ApplyConditionsNotFulfilled: "applyConditionsNotFulfilled",
+	EV_ComponentApplying:           "componentApplying",
+	EV_ComponentApplyRetry:         "componentApplyRetry",
+	EV_TestReadiness:               "testReadiness",
+	EV_ComponentReady:              "componentReady",
+	EV_ComponentApplied:            "componentApplied",
+	EV_ReadinessNotFulfilled:       "readinessNotFulfilled",
+	EV_ComponentSkipped:            "componentSkipped",
+	EV_ComponentFailed:             "componentFailed",
+	EV_ConditionEvaluated:          "conditionEvaluated",
+	EV_RunFinished:                 "runFinished",
+}
+
+func (id EventID) String() string {
+	return eventNames[id]
+}
+
+// The phases conditions are evaluated in
+const (
+	PhaseApplyConditions     = "applyConditions"
+	PhaseReadinessConditions = "readinessConditions"
+)
+
+// RunEvent reports the progress of Run.Run. Which fields are set depends on the ID.
+type RunEvent struct {
+	ID        EventID
+	Component *playbook.Component
+
+	// Time is when the event occurred
+	Time time.Time
+
+	// Elapsed is the time since the component has been started, or since the run
+	// has been started for EV_RunFinished
+	Elapsed time.Duration
+
+	// Attempt is the number of the apply attempt or readiness test, starting with 1
+	Attempt int
+
+	// Backoff is the time waited before the next apply attempt or readiness test
+	Backoff time.Duration
+
+	// Reason is the message of the condition which is not fulfilled, or why the component is skipped
+	Reason string
+
+	// Output is the output of kustomize and kubectl, when the component has been applied
+	Output string
+
+	// Phase and Condition are the phase and the result of EV_ConditionEvaluated
+	Phase     string
+	Condition *playbook.ConditionResult
+
+	// Summary is the result of the run for EV_RunFinished
+	Summary *RunSummary
+
+	// Err is the error of a failed apply attempt, a failed component or run
+	Err error
+}
+
+// RunSummary counts the components by their result
+type RunSummary struct {
+	Ready   int
+	Skipped int
+	Failed  int
+
+	// Pending components have not been processed, because a component before has failed
+	Pending int
+
+	Duration time.Duration
+}
+
+// newEvent creates an event of the component, which occurred now
+func newEvent(id EventID, c *RunComponent) RunEvent {
+	now := time.Now()
+	ev := RunEvent{ID: id, Component: &c.Component, Time: now}
+	if !c.started.IsZero() {
+		ev.Elapsed = now.Sub(c.started)
+	}
+
+	return ev
+}
+
+// conditionEvents returns the callback for playbook.EvalContext, which sends an
+// EV_ConditionEvaluated event for every evaluated condition
+func conditionEvents(c *RunComponent, phase string, attempt int, events chan<- RunEvent) func(result playbook.ConditionResult) {
+	return func(result playbook.ConditionResult) {
+		ev := newEvent(EV_ConditionEvaluated, c)
+		ev.Phase = phase
+		ev.Attempt = attempt
+		ev.Condition = &result
+		events <- ev
+	}
+}
+
+// jsonEvent is the JSON representation of a RunEvent, durations are in seconds
+type jsonEvent struct {
+	Time      time.Time      `json:"time"`
+	Event     string         `json:"event"`
+	Component string         `json:"component,omitempty"`
+	Cluster   string         `json:"cluster,omitempty"`
+	Elapsed   float64        `json:"elapsed,omitempty"`
+	Attempt   int            `json:"attempt,omitempty"`
+	Backoff   float64        `json:"backoff,omitempty"`
+	Reason    string         `json:"reason,omitempty"`
+	Output    string         `json:"output,omitempty"`
+	Phase     string         `json:"phase,omitempty"`
+	Condition *jsonCondition `json:"condition,omitempty"`
+	Summary   *jsonSummary   `json:"summary,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+type jsonCondition struct {
+	Type      string `json:"type"`
+	Cluster   string `json:"cluster,omitempty"`
+	Fulfilled bool   `json:"fulfilled"`
+	Reason    string `json:"reason,omitempty"`
+	Actual    string `json:"actual,omitempty"`
+	Expected  string `json:"expected,omitempty"`
+}
+
+type jsonSummary struct {
+	Ready    int     `json:"ready"`
+	Skipped  int     `json:"skipped"`
+	Failed   int     `json:"failed"`
+	Pending  int     `json:"pending"`
+	Duration float64 `json:"duration"`
+}
+
+// MarshalJSON writes the event as a flat object, with the name of the event and the component
+func (ev RunEvent) MarshalJSON() ([]byte, error) {
+	je := jsonEvent{
+		Time:    ev.Time,
+		Event:   ev.ID.String(),
+		Elapsed: ev.Elapsed.Seconds(),
+		Attempt: ev.Attempt,
+		Backoff: ev.Backoff.Seconds(),
+		Reason:  ev.Reason,
+		Output:  ev.Output,
+		Phase:   ev.Phase,
+	}
+
+	if ev.Component != nil {
+		je.Component = ev.Component.Name
+		je.Cluster = ev.Component.Cluster
+	}
+
+	if c := ev.Condition; c != nil {
+		je.Condition = &jsonCondition{
+			Type:      c.Type,
+			Cluster:   c.Cluster,
+			Fulfilled: c.Fulfilled,
+			Reason:    c.Reason,
+			Actual:    c.Actual,
+			Expected:  c.Expected,
+		}
+	}
+
+	if s := ev.Summary; s != nil {
+		je.Summary = &jsonSummary{
+			Ready:    s.Ready,
+			Skipped:  s.Skipped,
+			Failed:   s.Failed,
+			Pending:  s.Pending,
+			Duration: s.Duration.Seconds(),
+		}
+	}
+
+	if ev.Err != nil {
+		je.Error = ev.Err.Error()
+	}
+
+	return json.Marshal(je)
+}
@@ -3,10 +3,11 @@ package execution
 import (
 	"bytes"
 	"context"
-	"fmt"
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/gprossliner/kustomizepb/knownerror"
@@ -31,6 +32,45 @@ type Options struct {
 	KubeContext string
 	Directory   string
 	Envs        map[string]string
+
+	// Sensitive are the names of variables which must not be written to disk
+	Sensitive map[string]bool
+
+	// Profile is the name of the profile to apply to the playbook
+	Profile string
+
+	// KustomizeBinary and KubectlBinary are the names or paths of the binaries
+	KustomizeBinary string
+	KubectlBinary   string
+
+	// Kustomize and Kubectl are the tools detected by DetectTools, they are detected by LoadRun if they are nil
+	Kustomize *Tool
+	Kubectl   *Tool
+
+	// Clusters are the clusters of the playbook, set by LoadRun
+	Clusters map[string]playbook.Cluster
+
+	// ClusterContexts override the contexts of the clusters by name
+	ClusterContexts map[string]string
+
+	// kubeAccesses are the KubeAccess of the clusters, by kubeconfig and context
+	kubeAccesses map[string]*kubeaccess.KubeAccess
+
+	// verifiedClusters are the names of the clusters whose identity has been verified
+	verifiedClusters map[string]bool
+}
+
+// EvalContext creates the context for evaluating conditions
+func (options *Options) EvalContext() *playbook.EvalContext {
+	return &playbook.EvalContext{
+		KubeAccess:  options.KubeAccess,
+		KubeConfig:  options.KubeConfig,
+		KubeContext: options.KubeContext,
+		Directory:   options.Directory,
+		Envs:        options.Envs,
+
+		ClusterAccess: options.clusterAccess,
+	}
 }
 
 type RunComponent struct {
@@ -39,90 +79,101 @@ type RunComponent struct {
 
 	Applied bool
 	Ready   bool
+
+	// Skipped is true if the applyConditions are not fulfilled
+	Skipped bool
+
+	// started is when the component has been started, for the elapsed time of the events
+	started time.Time
 }
 
 type Run struct {
 	Directory             string
 	KustomizationFilePath string
 	Components            []RunComponent
+
+	// Kustomize and Kubectl are the detected local binaries
+	Kustomize *Tool
+	Kubectl   *Tool
+
+	// Vars are the variables for envsubst, including the outputs of ready components
+	Vars           map[string]string
+	StrictEnvsubst bool
+
+	masking *masking
 }
 
-func LoadRun(ctx context.Context, options *Options) (*Run, error) {
+// LoadRun prepares the run of the playbook, which has been loaded from options.Directory by LoadPlaybook.
+// The cluster identity, the variables and the prerequisites are checked, before anything is applied.
+func LoadRun(ctx context.Context, pb *playbook.Playbook, options *Options) (*Run, error) {
 
 	var err error
 
-	// check directory
 	directory := options.Directory
 
-	stat, err := os.Stat(directory)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, knownerror.NewKnownError("Directoy %s doesn't exist", directory)
-		}
-		return nil, err
+	// generate output file
+	kustomizationFile := path.Join(directory, KustomizationFileName)
+	if pathExists(kustomizationFile) {
+		return nil, knownerror.NewKnownError("There is already a file %s, which is not supported", KustomizationFileName)
 	}
 
-	if !stat.IsDir() {
-		return nil, knownerror.NewKnownError("%s is not a directory", directory)
+	// check the local tools, before anything touches the cluster
+	kustomize, kubectl := options.Kustomize, options.Kubectl
+	if kustomize == nil || kubectl == nil {
+		kustomize, kubectl, err = DetectTools(pb, options.KustomizeBinary, options.KubectlBinary)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	playbookFile := path.Join(directory, PlaybookFileName)
-	stat, err = os.Stat(playbookFile)
+	err = options.resolveClusters(pb)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, knownerror.NewKnownError("File %s doesn't exist", playbookFile)
-		}
 		return nil, err
-	} else if !stat.Mode().IsRegular() {
-		return nil, knownerror.NewKnownError("Path %s is not a regular file", directory)
 	}
 
-	// generate output file
-	kustomizationFile := path.Join(directory, KustomizationFileName)
-	if pathExists(kustomizationFile) {
-		return nil, knownerror.NewKnownError("There is already a file %s, which is not supported", KustomizationFileName)
+	// verify the cluster identity, before anything touches the cluster
+	identity := pb.ClusterIdentity.WithEnvs(options.Envs)
+	if identity != nil {
+		err = identity.Verify(ctx, options.KubeAccess)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	// deserialize file
-	data, err := os.ReadFile(playbookFile)
+	err = options.verifyClusters(ctx, pb)
 	if err != nil {
 		return nil, err
 	}
 
-	if err != nil {
-		return nil, err
+	// apply defaults and validate the variables, before anything is rendered
+	envs, errs := pb.ResolveVars(options.Envs)
+	if len(errs) > 0 {
+		return nil, joinErrors(errs)
 	}
+	options.Envs = envs
 
-	// load
-	playbook, err := playbook.Unmarshal(data)
+	// components are substituted when they are processed, because outputs of
+	// other components are not available yet
+	err = pb.CheckUnresolvedVars(options.Envs)
 	if err != nil {
 		return nil, err
 	}
 
-	// validate
-	errs := playbook.Validate()
-	if len(errs) > 0 {
-		for _, err := range errs {
-			fmt.Println(err.Error())
-		}
-		os.Exit(1)
-	}
-
-	// perform envsubst
-	err = playbook.EnvSubst(options.Envs)
+	err = pb.EnvSubstPrerequisites(options.Envs)
 	if err != nil {
 		return nil, err
 	}
 
 	// validate prerequisites
-	for _, pr := range playbook.Prerequisites {
-		isff, err := pr.IsFulfilled(ctx, options.KubeAccess)
+	ec := options.EvalContext()
+	for _, pr := range pb.Prerequisites {
+		isff, err := pr.IsFulfilled(ctx, ec)
 		if err != nil {
 			return nil, err
 		}
 
 		if !isff {
-			msg := pr.Message
+			msg := ec.Reason
 			if msg == "" {
 				msg = "Prerequisite check failed"
 			}
@@ -134,10 +185,23 @@ func LoadRun(ctx context.Context, options *Options) (*Run, error) {
 	run := &Run{
 		Directory:             directory,
 		KustomizationFilePath: kustomizationFile,
+		Kustomize:             kustomize,
+		Kubectl:               kubectl,
+		Vars:                  map[string]string{},
+		StrictEnvsubst:        pb.IsStrictEnvsubst(),
+	}
+
+	for k, v := range options.Envs {
+		run.Vars[k] = v
+	}
+
+	run.masking, err = newMasking(options.Envs, options.Sensitive)
+	if err != nil {
+		return nil, err
 	}
 
-	components := make([]RunComponent, len(playbook.Components))
-	for i, c := range playbook.Components {
+	components := make([]RunComponent, len(pb.Components))
+	for i, c := range pb.Components {
 		components[i] = RunComponent{Component: c, Run: run}
 	}
 
@@ -147,6 +211,162 @@ func LoadRun(ctx context.Context, options *Options) (*Run, error) {
 
 }
 
+// LoadPlaybook loads and validates the playbook in the directory, including all included playbooks.
+// If profile is not empty, the profile is applied before the playbook is validated.
+// All validation errors are returned as a single KnownError.
+func LoadPlaybook(directory string, profile string) (*playbook.Playbook, error) {
+	pb, errs := ValidatePlaybook(directory, profile)
+	if len(errs) > 0 {
+		return nil, joinErrors(errs)
+	}
+
+	return pb, nil
+}
+
+// ValidatePlaybook loads the playbook in the directory like LoadPlaybook, and returns all errors.
+// Besides Playbook.Validate, the local paths of the kustomizations need to exist.
+func ValidatePlaybook(directory string, profile string) (*playbook.Playbook, []error) {
+	pb, err := loadPlaybook(directory, profile)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	errs := pb.Validate()
+	errs = append(errs, pb.ValidatePaths(directory)...)
+
+	return pb, errs
+}
+
+// loadPlaybook reads the playbook, resolves the includes, expands the components and applies the profile
+func loadPlaybook(directory string, profile string) (*playbook.Playbook, error) {
+
+	pb, err := readPlaybook(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	err = resolveIncludes(pb, directory, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pb.Expand()
+	if err != nil {
+		return nil, err
+	}
+
+	if profile != "" {
+		err = pb.ApplyProfile(profile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return pb, nil
+}
+
+// readPlaybook reads the playbook file in the directory, without resolving includes
+func readPlaybook(directory string) (*playbook.Playbook, error) {
+
+	// check directory
+	stat, err := os.Stat(directory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, knownerror.NewKnownError("Directoy %s doesn't exist", directory)
+		}
+		return nil, err
+	}
+
+	if !stat.IsDir() {
+		return nil, knownerror.NewKnownError("%s is not a directory", directory)
+	}
+
+	playbookFile := path.Join(directory, PlaybookFileName)
+	stat, err = os.Stat(playbookFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, knownerror.NewKnownError("File %s doesn't exist", playbookFile)
+		}
+		return nil, err
+	} else if !stat.Mode().IsRegular() {
+		return nil, knownerror.NewKnownError("Path %s is not a regular file", directory)
+	}
+
+	// deserialize file
+	data, err := os.ReadFile(playbookFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// load
+	return playbook.Decode(data, playbookFile)
+}
+
+// resolveIncludes merges the included playbooks recursively. Parents are the absolute
+// directories of the including playbooks, to detect cycles.
+func resolveIncludes(pb *playbook.Playbook, directory string, parents []string) error {
+
+	absDirectory, err := filepath.Abs(directory)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range parents {
+		if p == absDirectory {
+			return knownerror.NewKnownError("Playbook %s includes itself, directly or through other includes", directory)
+		}
+	}
+	parents = append(parents, absDirectory)
+
+	// included components and prerequisites are processed before the own ones
+	components, prerequisites := pb.Components, pb.Prerequisites
+	pb.Components, pb.Prerequisites = nil, nil
+
+	names := map[string]bool{}
+	for _, inc := range pb.Includes {
+		err := inc.Validate()
+		if err != nil {
+			return err
+		}
+
+		if names[inc.Name] {
+			return knownerror.NewKnownError("Include name '%s' is used more than once", inc.Name)
+		}
+		names[inc.Name] = true
+
+		incDirectory := filepath.Join(directory, inc.Path)
+		included, err := readPlaybook(incDirectory)
+		if err != nil {
+			return err
+		}
+
+		err = resolveIncludes(included, incDirectory, parents)
+		if err != nil {
+			return err
+		}
+
+		err = included.Expand()
+		if err != nil {
+			return err
+		}
+
+		errs := included.Validate()
+		if len(errs) > 0 {
+			return joinErrors(errs)
+		}
+
+		err = pb.Merge(inc, included, inc.Path)
+		if err != nil {
+			return err
+		}
+	}
+
+	pb.Components = append(pb.Components, components...)
+	pb.Prerequisites = append(pb.Prerequisites, prerequisites...)
+
+	return nil
+}
+
 func (run *Run) GetComponent(name string) *RunComponent {
 	for i := range run.Components {
 		c := &run.Components[i]
@@ -158,93 +378,188 @@ func (run *Run) GetComponent(name string) *RunComponent {
 	return nil
 }
 
-type EventID int
-
-const (
-	EV_ComponentStarted EventID = iota
-	EV_TestApplyConditions
-	EV_ApplyConditionsNotFulfilled
-	EV_ComponentApplying
-	EV_ComponentApplyRetry
-	EV_TestReadiness
-	EV_ComponentReady
-)
+// Run applies the components one after another. All events are sent to events, the last one is
+// EV_RunFinished with the summary of the run, also if the run fails.
+func (run *Run) Run(ctx context.Context, options *Options, events chan<- RunEvent) error {
 
-type RunEvent struct {
-	ID        EventID
-	Component *playbook.Component
-}
+	started := time.Now()
+	summary := &RunSummary{}
 
-func (run *Run) Run(ctx context.Context, options *Options, events chan<- RunEvent) error {
+	ec := options.EvalContext()
+	ec.Envs = run.Vars
 
+	var err error
 	for i := range run.Components {
 		c := &run.Components[i]
 
-		events <- RunEvent{EV_ComponentStarted, &c.Component}
+		err = run.runComponent(ctx, c, ec, events)
+		if err != nil {
+			ev := newEvent(EV_ComponentFailed, c)
+			ev.Err = err
+			events <- ev
 
-		// check conditions
-		if len(c.ApplyConditions) > 0 {
-			events <- RunEvent{EV_TestApplyConditions, &c.Component}
-			isff, err := c.ApplyConditions.IsFulfilled(ctx, options.KubeAccess)
-			if err != nil {
-				return err
-			}
+			summary.Failed++
+			summary.Pending = len(run.Components) - i - 1
+			break
+		}
 
-			if !isff {
-				events <- RunEvent{EV_ApplyConditionsNotFulfilled, &c.Component}
-				continue
-			}
+		if c.Skipped {
+			summary.Skipped++
+		} else {
+			summary.Ready++
+		}
+	}
+
+	summary.Duration = time.Since(started)
+	events <- RunEvent{ID: EV_RunFinished, Time: time.Now(), Elapsed: summary.Duration, Summary: summary, Err: err}
+
+	return err
+}
+
+// runComponent applies the component, and waits until it's ready
+func (run *Run) runComponent(ctx context.Context, c *RunComponent, ec *playbook.EvalContext, events chan<- RunEvent) error {
+
+	c.started = time.Now()
+	events <- newEvent(EV_ComponentStarted, c)
+	ec.Envs = c.Vars(run.Vars)
+
+	// the component and its conditions are applied to the cluster of the component
+	cec, err := ec.ForCluster(ctx, c.Cluster)
+	if err != nil {
+		return err
+	}
+
+	// sensitive values are substituted after the kustomization is rendered
+	kustomization := c.Kustomization
+	err = c.EnvSubst(run.Vars, run.StrictEnvsubst)
+	if err != nil {
+		return err
+	}
 
+	if c.Envsubst {
+		c.Kustomization, err = kustomization.EnvSubst(c.Vars(run.masking.maskedVars(run.Vars)))
+		if err != nil {
+			return err
 		}
+	}
 
-		events <- RunEvent{EV_ComponentApplying, &c.Component}
+	// check conditions
+	if len(c.ApplyConditions) > 0 {
+		events <- newEvent(EV_TestApplyConditions, c)
+		cec.Evaluated = conditionEvents(c, PhaseApplyConditions, 1, events)
+		isff, err := c.ApplyConditions.IsFulfilled(ctx, cec)
+		if err != nil {
+			return err
+		}
 
-		for rcnt := 0; ; rcnt++ {
-			err := c.Apply(ctx, options)
-			if err != nil {
-				if rcnt == 15 {
-					return err
-				} else {
-					events <- RunEvent{EV_ComponentApplyRetry, &c.Component}
-					time.Sleep(time.Duration(rcnt) * time.Second)
-				}
+		if !isff {
+			ev := newEvent(EV_ApplyConditionsNotFulfilled, c)
+			ev.Reason = cec.Reason
+			events <- ev
+
+			c.Skipped = true
+			ev = newEvent(EV_ComponentSkipped, c)
+			ev.Reason = "applyConditions not fulfilled: " + cec.Reason
+			events <- ev
+			return nil
+		}
+
+	}
+
+	events <- newEvent(EV_ComponentApplying, c)
+
+	for rcnt := 0; ; rcnt++ {
+		output, err := c.Apply(ctx, cec)
+		if err != nil {
+			if rcnt == 15 {
+				return err
 			} else {
-				break
+				backoff := time.Duration(rcnt) * time.Second
+				ev := newEvent(EV_ComponentApplyRetry, c)
+				ev.Attempt = rcnt + 1
+				ev.Backoff = backoff
+				ev.Output = output
+				ev.Err = err
+				events <- ev
+				time.Sleep(backoff)
 			}
+		} else {
+			ev := newEvent(EV_ComponentApplied, c)
+			ev.Attempt = rcnt + 1
+			ev.Output = output
+			events <- ev
+			break
 		}
+	}
 
-		if len(c.ReadinessConditions) == 0 {
-			events <- RunEvent{EV_ComponentReady, &c.Component}
-		} else {
-			for i := 0; i < 40; i++ {
-				events <- RunEvent{EV_TestReadiness, &c.Component}
-
-				time.Sleep(time.Duration(i) * time.Second)
-				isff, err := c.CheckReadiness(ctx, options.KubeAccess)
-				if err != nil {
-					return err
-				}
-
-				if isff {
-					break
-				} else {
-					continue
-				}
+	if len(c.ReadinessConditions) == 0 {
+		c.Ready = true
+		events <- newEvent(EV_ComponentReady, c)
+	} else {
+		for i := 0; i < 40; i++ {
+			backoff := time.Duration(i) * time.Second
+			ev := newEvent(EV_TestReadiness, c)
+			ev.Attempt = i + 1
+			ev.Backoff = backoff
+			events <- ev
+
+			time.Sleep(backoff)
+			cec.Evaluated = conditionEvents(c, PhaseReadinessConditions, i+1, events)
+			isff, err := c.CheckReadiness(ctx, cec)
+			if err != nil {
+				return err
 			}
 
-			if c.Ready {
-				events <- RunEvent{EV_ComponentReady, &c.Component}
-			} else {
-				return knownerror.NewKnownError("RedinessConditions are not fulfilled")
+			if isff {
+				break
 			}
+
+			ev = newEvent(EV_ReadinessNotFulfilled, c)
+			ev.Attempt = i + 1
+			ev.Reason = cec.Reason
+			events <- ev
+		}
+
+		if c.Ready {
+			events <- newEvent(EV_ComponentReady, c)
+		} else {
+			return knownerror.NewKnownError("RedinessConditions are not fulfilled: %s", cec.Reason)
 		}
+	}
+
+	// provide the outputs to the following components
+	outputs, err := c.GetOutputs(ctx, cec)
+	if err != nil {
+		return err
+	}
 
-		c.Applied = true
+	for k, v := range outputs {
+		run.Vars[k] = v
 	}
 
+	// outputs read from Secrets are not written to disk, like sensitive variables
+	for _, o := range c.Outputs {
+		if o.IsSensitive() {
+			if err := run.masking.add(o.Name, outputs[o.Name]); err != nil {
+				return err
+			}
+		}
+	}
+
+	c.Applied = true
 	return nil
 }
 
+// joinErrors combines multiple KnownErrors to a single one, printed on separate lines
+func joinErrors(errs []error) error {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+
+	return knownerror.NewKnownError("%s", strings.Join(msgs, "\n"))
+}
+
 func pathExists(path string) bool {
 	_, err := os.Stat(path)
 	if err != nil {
@@ -258,8 +573,8 @@ func pathExists(path string) bool {
 	return true
 }
 
-func (c *RunComponent) CheckReadiness(ctx context.Context, ka *kubeaccess.KubeAccess) (bool, error) {
-	ready, err := c.ReadinessConditions.IsFulfilled(ctx, ka)
+func (c *RunComponent) CheckReadiness(ctx context.Context, ec *playbook.EvalContext) (bool, error) {
+	ready, err := c.ReadinessConditions.IsFulfilled(ctx, ec)
 
 	if err != nil {
 		return false, err
@@ -269,55 +584,59 @@ func (c *RunComponent) CheckReadiness(ctx context.Context, ka *kubeaccess.KubeAc
 	return ready, nil
 }
 
-func (c *RunComponent) Apply(ctx context.Context, options *Options) error {
-	manifestData, err := buildKustomization(ctx, c.Kustomization, c.Run.KustomizationFilePath)
+// Apply applies the component to the cluster of the EvalContext.
+// It returns the output of kustomize and kubectl, which is not printed.
+func (c *RunComponent) Apply(ctx context.Context, ec *playbook.EvalContext) (string, error) {
+	manifestData, kustomizeOutput, err := buildKustomization(ctx, c.Run.Kustomize, c.Kustomization, c.Run.KustomizationFilePath)
 	if err != nil {
-		return err
+		return kustomizeOutput, err
 	}
 
-	err = applyManifest(ctx, manifestData, options)
+	manifestData, err = c.Run.masking.unmask(manifestData)
 	if err != nil {
-		return err
+		return kustomizeOutput, err
+	}
+
+	kubectlOutput, err := applyManifest(ctx, c.Run.Kubectl, manifestData, ec.KubeConfig, ec.KubeContext)
+	output := strings.TrimSpace(kustomizeOutput + "\n" + kubectlOutput)
+	if err != nil {
+		return output, err
 	}
 
 	c.Applied = true
 
-	return nil
+	return output, nil
 }
 
-func applyManifest(ctx context.Context, manifest []byte, options *Options) error {
-	f, err := os.CreateTemp("", "")
-	if err != nil {
-		return err
-	}
+// applyManifest pipes the manifest to kubectl, so it's never written to disk.
+// It returns the output of kubectl.
+func applyManifest(ctx context.Context, kubectl *Tool, manifest []byte, kubeconfig string, kubecontext string) (string, error) {
 
-	defer f.Close()
-	defer os.Remove(f.Name())
-
-	_, err = f.Write(manifest)
-	if err != nil {
-		return err
+	// execute kubectl
+	args := []string{"--kubeconfig", kubeconfig}
+	if kubecontext != "" {
+		args = append(args, "--context", kubecontext)
 	}
+	args = append(args, "apply", "-f", "-")
+	cmd := exec.Command(kubectl.Path, args...)
+	cmd.Stdin = bytes.NewReader(manifest)
 
-	// execute kubectl
-	args := []string{"--kubeconfig", options.KubeConfig}
-	if options.KubeContext != "" {
-		args = append(args, "--context", options.KubeContext)
-	}
-	args = append(args, "apply", "-f", f.Name())
-	cmd := exec.Command("kubectl", args...)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	err = cmd.Run()
+	var outbuff bytes.Buffer
+	cmd.Stderr = &outbuff
+	cmd.Stdout = &outbuff
+	err := cmd.Run()
+	output := strings.TrimSpace(outbuff.String())
 
 	if err != nil {
-		return err
+		return output, knownerror.NewKnownError("kubectl apply failed: %s: %s", err, output)
 	}
 
-	return nil
+	return output, nil
 }
 
-func buildKustomization(ctx context.Context, kustomization playbook.Kustomization, kustomizationFilePath string) ([]byte, error) {
+// buildKustomization renders the kustomization. It returns the manifest, and the messages
+// of kustomize, like warnings.
+func buildKustomization(ctx context.Context, kustomize *Tool, kustomization playbook.Kustomization, kustomizationFilePath string) ([]byte, string, error) {
 
 	if pathExists(kustomizationFilePath) {
 		panic("ASSERT failed, path was pre-validated to not exist")
@@ -326,29 +645,34 @@ func buildKustomization(ctx context.Context, kustomization playbook.Kustomizatio
 	// serialize
 	data, err := yaml.Marshal(kustomization)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	// write to Kustomization file
 	err = os.WriteFile(kustomizationFilePath, data, 0666)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	defer os.Remove(kustomizationFilePath)
 
 	// execute kustomize build
-	cmd := exec.Command("kustomize", "build", path.Dir(kustomizationFilePath))
-	cmd.Stderr = os.Stderr
+	args := []string{"build", path.Dir(kustomizationFilePath)}
+	if kustomization.LoadsOutsideRoot() {
+		args = append(args, loadRestrictorArgs(kustomize)...)
+	}
+	cmd := exec.Command(kustomize.Path, args...)
 
-	var outbuff bytes.Buffer
+	var outbuff, errbuff bytes.Buffer
 	cmd.Stdout = &outbuff
+	cmd.Stderr = &errbuff
 
 	err = cmd.Run()
+	messages := strings.TrimSpace(errbuff.String())
 
 	if err != nil {
-		return nil, err
+		return nil, messages, knownerror.NewKnownError("kustomize build failed: %s: %s", err, messages)
 	}
 
-	return outbuff.Bytes(), nil
+	return outbuff.Bytes(), messages, nil
 }
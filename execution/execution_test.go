@@ -0,0 +1,637 @@
+package execution
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gprossliner/kustomizepb/knownerror"
+	"github.com/gprossliner/kustomizepb/kubeaccess"
+	"github.com/gprossliner/kustomizepb/playbook"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKustomizeVersion(t *testing.T) {
+	v, err := parseKustomizeVersion([]byte("v5.0.1\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "v5.0.1", v)
+
+	v, err = parseKustomizeVersion([]byte("{Version:kustomize/v4.5.7 GitCommit:56d82a8378dfc8dc3b3b1085e5a6e67b82966bd7 BuildDate:2022-08-02T16:35:54Z GoOs:linux GoArch:amd64}"))
+	assert.NoError(t, err)
+	assert.Equal(t, "v4.5.7", v)
+
+	_, err = parseKustomizeVersion([]byte("unknown"))
+	assert.Error(t, err)
+}
+
+func TestParseKubectlVersion(t *testing.T) {
+	v, err := parseKubectlVersion([]byte(`{"clientVersion": {"major": "1", "minor": "26", "gitVersion": "v1.26.0"}, "kustomizeVersion": "v4.5.7"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.26.0", v)
+
+	_, err = parseKubectlVersion([]byte(`{}`))
+	assert.Error(t, err)
+}
+
+func TestDetectTool(t *testing.T) {
+	// a fake kustomize binary
+	binary := filepath.Join(t.TempDir(), "kustomize")
+	err := os.WriteFile(binary, []byte("#!/bin/sh\necho v4.5.7\n"), 0755)
+	assert.NoError(t, err)
+
+	tool, err := detectTool("kustomize", binary, ">= 4.5", kustomizeVersion)
+	assert.NoError(t, err)
+	assert.Equal(t, binary, tool.Path)
+	assert.Equal(t, "v4.5.7", tool.Version)
+
+	_, err = detectTool("kustomize", binary, ">= 5.0", kustomizeVersion)
+	assert.Error(t, err)
+	assert.Regexp(t, "doesn't satisfy", err.Error())
+
+	_, err = detectTool("kustomize", "kustomize-doesnotexist", "", kustomizeVersion)
+	assert.Error(t, err)
+	assert.Regexp(t, "not found", err.Error())
+
+	_, _, err = DetectTools(&playbook.Playbook{}, "kustomize-doesnotexist", "")
+	assert.EqualError(t, err, "kustomize binary 'kustomize-doesnotexist' not found: exec: \"kustomize-doesnotexist\": executable file not found in $PATH")
+}
+
+func TestMasking(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	vars := map[string]string{"PASSWORD": "verysecret", "HOST": "db"}
+	m, err := newMasking(vars, map[string]bool{"PASSWORD": true, "NOTDEFINED": true})
+	assert.NoError(t, err)
+
+	masked := m.maskedVars(vars)
+	assert.Equal(t, "db", masked["HOST"])
+	assert.NotEqual(t, "verysecret", masked["PASSWORD"])
+	assert.Regexp(t, "^kustomizepb-sensitive-", masked["PASSWORD"])
+
+	// kustomize may encode the placeholder, like for secretGenerator literals
+	encoded := base64.StdEncoding.EncodeToString([]byte(masked["PASSWORD"]))
+	manifest := "stringData:\n  password: " + masked["PASSWORD"] + "\ndata:\n  password: " + encoded + "\n"
+
+	unmasked, err := m.unmask([]byte(manifest))
+	assert.NoError(t, err)
+	assert.Equal(t, "stringData:\n  password: verysecret\ndata:\n  password: dmVyeXNlY3JldA==\n", string(unmasked))
+
+	// plain placeholders are also replaced as part of a value
+	unmasked, err = m.unmask([]byte("data:\n  url: https://u:" + masked["PASSWORD"] + "@db\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "data:\n  url: https://u:verysecret@db\n", string(unmasked))
+
+	// but not if they are encoded as part of a value, like for secretGenerator files
+	encoded = base64.StdEncoding.EncodeToString([]byte("url=https://u:" + masked["PASSWORD"] + "@db"))
+	_, err = m.unmask([]byte("kind: Secret\ndata:\n  url: " + encoded + "\n---\nkind: Secret\n"))
+	assert.EqualError(t, err, "The sensitive variable PASSWORD is used in a value kustomize has encoded (line 3 of the rendered manifest), "+
+		"sensitive variables can only be used as whole values")
+
+	// the placeholders are the same for every run with the same value, so the name hash suffix
+	// of generators only changes if the value changes
+	m2, err := newMasking(vars, map[string]bool{"PASSWORD": true})
+	assert.NoError(t, err)
+	assert.Equal(t, masked["PASSWORD"], m2.maskedVars(vars)["PASSWORD"])
+
+	m2, err = newMasking(map[string]string{"PASSWORD": "changed"}, map[string]bool{"PASSWORD": true})
+	assert.NoError(t, err)
+	assert.NotEqual(t, masked["PASSWORD"], m2.maskedVars(vars)["PASSWORD"])
+
+	// the key of the placeholders is created once
+	key, err := os.ReadFile(filepath.Join(os.Getenv("XDG_CONFIG_HOME"), "kustomizepb", placeholderKeyFileName))
+	assert.NoError(t, err)
+	assert.Equal(t, m.key, key)
+	assert.Len(t, key, 32)
+
+	// outputs read from Secrets are added when they are available
+	vars["TOKEN"] = "fromsecret"
+	assert.NoError(t, m.add("TOKEN", "fromsecret"))
+	masked = m.maskedVars(vars)
+	assert.Regexp(t, "^kustomizepb-sensitive-", masked["TOKEN"])
+
+	unmasked, err = m.unmask([]byte("data:\n  token: " + base64.StdEncoding.EncodeToString([]byte(masked["TOKEN"])) + "\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "data:\n  token: ZnJvbXNlY3JldA==\n", string(unmasked))
+}
+
+func writePlaybook(t *testing.T, directory string, content string) {
+	err := os.MkdirAll(directory, 0755)
+	assert.NoError(t, err)
+
+	header := "apiVersion: " + playbook.ApiVersion + "\nkind: " + playbook.Kind + "\n"
+	err = os.WriteFile(filepath.Join(directory, PlaybookFileName), []byte(header+content), 0644)
+	assert.NoError(t, err)
+}
+
+func TestLoadPlaybookIncludes(t *testing.T) {
+	dir := t.TempDir()
+
+	writePlaybook(t, filepath.Join(dir, "base"), `
+components:
+- name: crds
+  kustomization:
+    resources:
+    - crds
+`)
+
+	writePlaybook(t, filepath.Join(dir, "platform"), `
+includes:
+- name: base
+  path: ../base
+components:
+- name: cert-manager
+  dependsOn:
+  - base/crds
+  kustomization:
+    resources:
+    - cert-manager
+`)
+
+	writePlaybook(t, filepath.Join(dir, "team"), `
+includes:
+- name: platform
+  path: ../platform
+components:
+- name: app
+  dependsOn:
+  - platform/cert-manager
+  kustomization:
+    resources:
+    - app
+`)
+
+	for _, p := range []string{"base/crds", "platform/cert-manager", "team/app"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(dir, p), 0755))
+	}
+
+	pb, err := LoadPlaybook(filepath.Join(dir, "team"), "")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var names []string
+	for _, c := range pb.Components {
+		names = append(names, c.Name)
+	}
+	assert.Equal(t, []string{"platform/base/crds", "platform/cert-manager", "app"}, names)
+	assert.Equal(t, "platform/base/crds", pb.Components[1].DependsOn[0].Name)
+	assert.Equal(t, []interface{}{"../base/crds"}, pb.Components[0].Kustomization["resources"])
+	assert.Equal(t, []interface{}{"../platform/cert-manager"}, pb.Components[1].Kustomization["resources"])
+}
+
+func TestBuildIncludedKustomization(t *testing.T) {
+	path, err := exec.LookPath(DefaultKustomizeBinary)
+	if err != nil {
+		t.Skip(err)
+	}
+	kustomize := &Tool{Name: "kustomize", Path: path}
+
+	dir := t.TempDir()
+
+	writePlaybook(t, filepath.Join(dir, "platform"), `
+components:
+- name: config
+  kustomization:
+    resources:
+    - configmap.yaml
+    patches:
+    - path: patch.yaml
+    configMapGenerator:
+    - name: settings
+      files:
+      - settings.conf
+`)
+
+	writePlaybook(t, filepath.Join(dir, "team"), `
+includes:
+- name: platform
+  path: ../platform
+`)
+
+	files := map[string]string{
+		"configmap.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app\ndata:\n  level: info\n",
+		"patch.yaml":     "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app\ndata:\n  level: debug\n",
+		"settings.conf":  "enabled=true\n",
+	}
+	for name, content := range files {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "platform", name), []byte(content), 0644))
+	}
+
+	pb, err := LoadPlaybook(filepath.Join(dir, "team"), "")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// the files of the included playbook are outside of the directory of the generated kustomization
+	k := pb.Components[0].Kustomization
+	assert.True(t, k.LoadsOutsideRoot())
+
+	manifest, _, err := buildKustomization(context.Background(), kustomize, k, filepath.Join(dir, "team", KustomizationFileName))
+	assert.NoError(t, err)
+	assert.Contains(t, string(manifest), "level: debug")
+	assert.Contains(t, string(manifest), "enabled=true")
+}
+
+func TestLoadRestrictorArgs(t *testing.T) {
+	assert.Equal(t, []string{"--load-restrictor", "LoadRestrictionsNone"}, loadRestrictorArgs(&Tool{Version: "v5.0.1"}))
+	assert.Equal(t, []string{"--load-restrictor", "LoadRestrictionsNone"}, loadRestrictorArgs(&Tool{}))
+	assert.Equal(t, []string{"--load_restrictor", "LoadRestrictionsNone"}, loadRestrictorArgs(&Tool{Version: "3.8.1"}))
+}
+
+func TestLoadPlaybookIncludeCycle(t *testing.T) {
+	dir := t.TempDir()
+
+	writePlaybook(t, filepath.Join(dir, "a"), `
+includes:
+- name: b
+  path: ../b
+`)
+
+	writePlaybook(t, filepath.Join(dir, "b"), `
+includes:
+- name: a
+  path: ../a
+`)
+
+	_, err := LoadPlaybook(filepath.Join(dir, "a"), "")
+	assert.ErrorContains(t, err, "includes itself")
+}
+
+func TestClusterAccess(t *testing.T) {
+	options := &Options{KubeConfig: "/kubeconfig", KubeContext: "target", ClusterContexts: map[string]string{"workload": "override"}}
+	pb := &playbook.Playbook{Clusters: map[string]playbook.Cluster{
+		"default":  {},
+		"workload": {Context: "kind-workload", KubeConfig: "/other"},
+	}}
+
+	err := options.resolveClusters(pb)
+	assert.NoError(t, err)
+	assert.Equal(t, "override", options.Clusters["workload"].Context)
+	assert.Equal(t, "kind-workload", pb.Clusters["workload"].Context)
+
+	// the target cluster is reused
+	ca, err := options.clusterAccess(context.Background(), "default")
+	assert.NoError(t, err)
+	assert.Equal(t, "/kubeconfig", ca.KubeConfig)
+	assert.Equal(t, "target", ca.KubeContext)
+
+	_, err = options.clusterAccess(context.Background(), "unknown")
+	assert.Error(t, err)
+
+	options.ClusterContexts = map[string]string{"unknown": "ctx"}
+	assert.Error(t, options.resolveClusters(pb))
+}
+
+func TestClusterAccessIdentity(t *testing.T) {
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	err := os.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: prod
+  cluster:
+    server: https://prod.example.com
+- name: staging
+  cluster:
+    server: https://staging.example.com
+contexts:
+- name: prod
+  context:
+    cluster: prod
+- name: staging
+  context:
+    cluster: staging
+current-context: staging
+`), 0644)
+	assert.NoError(t, err)
+
+	options := &Options{KubeConfig: kubeconfig, KubeContext: "staging"}
+	pb := &playbook.Playbook{Clusters: map[string]playbook.Cluster{
+		"workload": {Context: "prod", Identity: &playbook.ClusterIdentity{Server: "https://prod.example.com"}},
+	}}
+
+	assert.NoError(t, options.resolveClusters(pb))
+	ca, err := options.clusterAccess(context.Background(), "workload")
+	assert.NoError(t, err)
+	assert.Equal(t, "prod", ca.KubeContext)
+	assert.True(t, options.verifiedClusters["workload"])
+
+	// the identity is also verified if the context is overridden
+	ka, err := kubeaccess.NewKubeAccess(kubeconfig, "staging")
+	assert.NoError(t, err)
+	options = &Options{KubeAccess: ka, KubeConfig: kubeconfig, KubeContext: "staging", ClusterContexts: map[string]string{"workload": "staging"}}
+	assert.NoError(t, options.resolveClusters(pb))
+	_, err = options.clusterAccess(context.Background(), "workload")
+	assert.EqualError(t, err, "Cluster 'workload': The cluster doesn't match the clusterIdentity: server is 'https://staging.example.com', not 'https://prod.example.com'")
+
+	// the identities of the used clusters are verified before the run, unused clusters are not accessed
+	pb.Clusters["unused"] = playbook.Cluster{Context: "staging", Identity: &playbook.ClusterIdentity{Server: "https://other.example.com"}}
+	options = &Options{KubeAccess: ka, KubeConfig: kubeconfig, KubeContext: "staging"}
+	assert.NoError(t, options.resolveClusters(pb))
+	assert.NoError(t, options.verifyClusters(context.Background(), pb))
+	assert.Empty(t, options.verifiedClusters)
+
+	pb.Components = []playbook.Component{{Name: "c1"}, {Name: "c2", Cluster: "workload"}}
+	assert.NoError(t, options.verifyClusters(context.Background(), pb))
+	assert.True(t, options.verifiedClusters["workload"])
+
+	pb.Prerequisites = playbook.ConditionSlice{{Cluster: "unused"}}
+	err = options.verifyClusters(context.Background(), pb)
+	assert.EqualError(t, err, "Cluster 'unused': The cluster doesn't match the clusterIdentity: server is 'https://staging.example.com', not 'https://other.example.com'")
+}
+
+func TestMigratePlaybook(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, PlaybookFileName)
+	err := os.WriteFile(file, []byte(`apiVersion: kustomizeplaybook.world-direct.at/v1beta1
+kind: KustomizationPlaybook
+components:
+- name: crds
+- name: app
+  dependsOn:
+  - name: crds # the crds
+`), 0644)
+	assert.NoError(t, err)
+
+	migrated, err := MigratePlaybook(dir)
+	assert.NoError(t, err)
+	assert.True(t, migrated)
+
+	data, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "apiVersion: "+playbook.ApiVersion+"\n")
+	assert.Contains(t, string(data), "- crds # the crds\n")
+	assert.Contains(t, string(data), "strictEnvsubst: false\n")
+
+	pb, err := LoadPlaybook(dir, "")
+	assert.NoError(t, err)
+	assert.Empty(t, pb.Warnings())
+	assert.False(t, pb.IsStrictEnvsubst())
+
+	migrated, err = MigratePlaybook(dir)
+	assert.NoError(t, err)
+	assert.False(t, migrated)
+}
+
+// newTestRun creates a run of the playbook with fake kustomize and kubectl binaries
+func newTestRun(t *testing.T, pb *playbook.Playbook, kubectlScript string) (*Run, *Options) {
+	dir := t.TempDir()
+
+	kustomize := filepath.Join(dir, "kustomize")
+	err := os.WriteFile(kustomize, []byte("#!/bin/sh\necho 'kind: ConfigMap'\necho 'Warning: deprecated field' >&2\n"), 0755)
+	assert.NoError(t, err)
+
+	kubectl := filepath.Join(dir, "kubectl")
+	err = os.WriteFile(kubectl, []byte("#!/bin/sh\ncat > /dev/null\n"+kubectlScript), 0755)
+	assert.NoError(t, err)
+
+	m, err := newMasking(nil, nil)
+	assert.NoError(t, err)
+
+	run := &Run{
+		Directory:             dir,
+		KustomizationFilePath: filepath.Join(dir, KustomizationFileName),
+		Kustomize:             &Tool{Name: "kustomize", Path: kustomize},
+		Kubectl:               &Tool{Name: "kubectl", Path: kubectl},
+		Vars:                  map[string]string{},
+		masking:               m,
+	}
+
+	for _, c := range pb.Components {
+		run.Components = append(run.Components, RunComponent{Component: c, Run: run})
+	}
+
+	return run, &Options{Directory: dir}
+}
+
+func TestRunEvents(t *testing.T) {
+	pb := &playbook.Playbook{Components: []playbook.Component{
+		{Name: "skipped", ApplyConditions: playbook.ConditionSlice{{Exec: &playbook.ExecCondition{Command: "false"}}}},
+		{Name: "app", Kustomization: playbook.Kustomization{"resources": []interface{}{"app"}}},
+	}}
+
+	run, options := newTestRun(t, pb, "echo 'configmap/app created'\n")
+
+	events := make(chan RunEvent, 100)
+	err := run.Run(context.Background(), options, events)
+	close(events)
+	assert.NoError(t, err)
+
+	var ids []EventID
+	byID := map[EventID]RunEvent{}
+	for ev := range events {
+		ids = append(ids, ev.ID)
+		byID[ev.ID] = ev
+		assert.False(t, ev.Time.IsZero())
+	}
+
+	assert.Equal(t, []EventID{
+		EV_ComponentStarted, EV_TestApplyConditions, EV_ConditionEvaluated, EV_ApplyConditionsNotFulfilled, EV_ComponentSkipped,
+		EV_ComponentStarted, EV_ComponentApplying, EV_ComponentApplied, EV_ComponentReady,
+		EV_RunFinished,
+	}, ids)
+
+	assert.Equal(t, "Command 'false' exited with code 1: ", byID[EV_ApplyConditionsNotFulfilled].Reason)
+	assert.Equal(t, "applyConditions not fulfilled: Command 'false' exited with code 1: ", byID[EV_ComponentSkipped].Reason)
+
+	evaluated := byID[EV_ConditionEvaluated]
+	assert.Equal(t, "skipped", evaluated.Component.Name)
+	assert.Equal(t, PhaseApplyConditions, evaluated.Phase)
+	assert.Equal(t, &playbook.ConditionResult{Type: "exec", Reason: "Command 'false' exited with code 1: "}, evaluated.Condition)
+
+	finished := byID[EV_RunFinished]
+	assert.NoError(t, finished.Err)
+	assert.Equal(t, 1, finished.Summary.Ready)
+	assert.Equal(t, 1, finished.Summary.Skipped)
+	assert.Equal(t, 0, finished.Summary.Failed)
+
+	assert.True(t, run.Components[0].Skipped)
+	assert.True(t, run.Components[1].Ready)
+
+	applied := byID[EV_ComponentApplied]
+
+	// the output of the tools is captured, and not printed
+	assert.Equal(t, 1, applied.Attempt)
+	assert.Equal(t, "Warning: deprecated field\nconfigmap/app created", applied.Output)
+
+	data, err := json.Marshal(applied)
+	assert.NoError(t, err)
+
+	var obj map[string]interface{}
+	assert.NoError(t, json.Unmarshal(data, &obj))
+	assert.Equal(t, "componentApplied", obj["event"])
+	assert.Equal(t, "app", obj["component"])
+	assert.Equal(t, float64(1), obj["attempt"])
+	assert.Equal(t, applied.Output, obj["output"])
+	assert.NotContains(t, obj, "error")
+}
+
+func TestRunEventJSON(t *testing.T) {
+	c := &playbook.Component{Name: "app", Cluster: "mgmt"}
+	ev := RunEvent{
+		ID:        EV_ComponentApplyRetry,
+		Component: c,
+		Time:      time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC),
+		Attempt:   2,
+		Err:       knownerror.NewKnownError("kubectl apply failed"),
+	}
+
+	data, err := json.Marshal(ev)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"time": "2023-01-02T03:04:05Z", "event": "componentApplyRetry", "component": "app", "cluster": "mgmt", "attempt": 2, "error": "kubectl apply failed"}`, string(data))
+
+	ev = RunEvent{
+		ID:        EV_ConditionEvaluated,
+		Component: c,
+		Time:      time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC),
+		Elapsed:   1500 * time.Millisecond,
+		Attempt:   3,
+		Phase:     PhaseReadinessConditions,
+		Condition: &playbook.ConditionResult{Type: "compare", Reason: "'1' is not equal to '3'", Actual: "1", Expected: "3"},
+	}
+
+	data, err = json.Marshal(ev)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"time": "2023-01-02T03:04:05Z", "event": "conditionEvaluated", "component": "app", "cluster": "mgmt", "elapsed": 1.5, "attempt": 3,
+		"phase": "readinessConditions", "condition": {"type": "compare", "fulfilled": false, "reason": "'1' is not equal to '3'", "actual": "1", "expected": "3"}}`, string(data))
+
+	ev = RunEvent{
+		ID:      EV_RunFinished,
+		Time:    time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC),
+		Elapsed: time.Minute,
+		Summary: &RunSummary{Ready: 2, Skipped: 1, Duration: time.Minute},
+	}
+
+	data, err = json.Marshal(ev)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"time": "2023-01-02T03:04:05Z", "event": "runFinished", "elapsed": 60, "summary": {"ready": 2, "skipped": 1, "failed": 0, "pending": 0, "duration": 60}}`, string(data))
+}
+
+func TestRunEventsFailed(t *testing.T) {
+	pb := &playbook.Playbook{Components: []playbook.Component{
+		{Name: "broken", ApplyConditions: playbook.ConditionSlice{{Exec: &playbook.ExecCondition{Command: "kustomizepb-doesnotexist"}}}},
+		{Name: "pending"},
+	}}
+
+	run, options := newTestRun(t, pb, "")
+
+	events := make(chan RunEvent, 100)
+	err := run.Run(context.Background(), options, events)
+	close(events)
+	assert.ErrorContains(t, err, "Unable to execute command")
+
+	var last []RunEvent
+	for ev := range events {
+		last = append(last, ev)
+	}
+	last = last[len(last)-2:]
+
+	assert.Equal(t, EV_ComponentFailed, last[0].ID)
+	assert.Equal(t, "broken", last[0].Component.Name)
+	assert.Equal(t, err, last[0].Err)
+
+	assert.Equal(t, EV_RunFinished, last[1].ID)
+	assert.Nil(t, last[1].Component)
+	assert.Equal(t, err, last[1].Err)
+	assert.Equal(t, RunSummary{Failed: 1, Pending: 1, Duration: last[1].Summary.Duration}, *last[1].Summary)
+}
+
+func TestReport(t *testing.T) {
+	pb := &playbook.Playbook{Components: []playbook.Component{
+		{Name: "skipped", ApplyConditions: playbook.ConditionSlice{{Exec: &playbook.ExecCondition{Command: "false"}}}},
+		{Name: "app", Kustomization: playbook.Kustomization{"resources": []interface{}{"app"}}},
+		{Name: "db", Cluster: "mgmt"},
+		{Name: "pending"},
+	}}
+
+	run, options := newTestRun(t, pb, "echo 'configmap/app created'\n")
+
+	// only skipped and app are run, the events of db are added below
+	report := NewReport(pb)
+	run.Components = run.Components[:2]
+
+	events := make(chan RunEvent, 100)
+	err := run.Run(context.Background(), options, events)
+	close(events)
+	assert.NoError(t, err)
+
+	for ev := range events {
+		report.Add(ev)
+	}
+
+	db := &playbook.Component{Name: "db", Cluster: "mgmt"}
+	report.Add(RunEvent{ID: EV_ComponentStarted, Component: db})
+	report.Add(RunEvent{ID: EV_ComponentApplyRetry, Component: db, Attempt: 1, Err: knownerror.NewKnownError("kubectl apply failed")})
+	report.Add(RunEvent{ID: EV_ComponentApplied, Component: db, Attempt: 2, Elapsed: 2 * time.Second})
+	report.Add(RunEvent{ID: EV_ReadinessNotFulfilled, Component: db, Attempt: 40, Elapsed: 10 * time.Second, Reason: "'0' is not equal to '1'"})
+	report.Add(RunEvent{ID: EV_ComponentFailed, Component: db, Elapsed: 10 * time.Second, Err: knownerror.NewKnownError("RedinessConditions are not fulfilled: '0' is not equal to '1'")})
+
+	assert.Len(t, report.Components, 4)
+
+	skipped, app := report.Components[0], report.Components[1]
+	assert.Equal(t, StatusSkipped, skipped.Status)
+	assert.Equal(t, 0, skipped.ApplyAttempts)
+	assert.Equal(t, "applyConditions not fulfilled: Command 'false' exited with code 1: ", skipped.Reason)
+
+	assert.Equal(t, StatusReady, app.Status)
+	assert.Equal(t, 1, app.ApplyAttempts)
+	assert.NotZero(t, app.TimeToApply)
+	assert.GreaterOrEqual(t, app.TimeToReady, app.TimeToApply)
+
+	assert.Equal(t, &ComponentReport{
+		Name: "db", Cluster: "mgmt", Status: StatusFailed, ApplyAttempts: 2, TimeToApply: 2 * time.Second, Elapsed: 10 * time.Second,
+		Reason: "'0' is not equal to '1'", Err: knownerror.NewKnownError("RedinessConditions are not fulfilled: '0' is not equal to '1'"),
+	}, report.Components[2])
+	assert.Equal(t, StatusPending, report.Components[3].Status)
+
+	// the durations are fixed for the comparison
+	report.Started = time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	report.Summary.Duration = 12 * time.Second
+	skipped.Elapsed = 100 * time.Millisecond
+	app.Elapsed = 1500 * time.Millisecond
+
+	var b bytes.Buffer
+	assert.NoError(t, report.WriteJUnit(&b, "playbook"))
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="playbook" tests="4" failures="1" skipped="2" time="12.000" timestamp="2023-01-02T03:04:05Z">
+    <testcase name="skipped" classname="playbook" time="0.100">
+      <skipped message="applyConditions not fulfilled: Command &#39;false&#39; exited with code 1: "></skipped>
+    </testcase>
+    <testcase name="app" classname="playbook" time="1.500"></testcase>
+    <testcase name="db" classname="playbook.mgmt" time="10.000">
+      <failure message="RedinessConditions are not fulfilled: &#39;0&#39; is not equal to &#39;1&#39;">&#39;0&#39; is not equal to &#39;1&#39;</failure>
+    </testcase>
+    <testcase name="pending" classname="playbook" time="0.000">
+      <skipped message="not processed, because a component before has failed"></skipped>
+    </testcase>
+  </testsuite>
+</testsuites>
+`, b.String())
+}
+
+func TestReportAbort(t *testing.T) {
+	pb := &playbook.Playbook{Components: []playbook.Component{{Name: "crds"}, {Name: "app", Cluster: "mgmt"}}}
+	report := NewReport(pb)
+	report.Abort(knownerror.NewKnownError("The cluster doesn't match the clusterIdentity"))
+
+	var b bytes.Buffer
+	assert.NoError(t, report.WriteJUnit(&b, "deploy"))
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="deploy" tests="2" failures="2" skipped="0" time="0.000">
+    <testcase name="crds" classname="deploy" time="0.000">
+      <failure message="The cluster doesn&#39;t match the clusterIdentity">The cluster doesn&#39;t match the clusterIdentity</failure>
+    </testcase>
+    <testcase name="app" classname="deploy.mgmt" time="0.000">
+      <failure message="The cluster doesn&#39;t match the clusterIdentity">The cluster doesn&#39;t match the clusterIdentity</failure>
+    </testcase>
+  </testsuite>
+</testsuites>
+`, b.String())
+}
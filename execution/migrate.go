@@ -0,0 +1,46 @@
+package execution
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+
+	"github.com/gprossliner/kustomizepb/knownerror"
+	"github.com/gprossliner/kustomizepb/playbook"
+)
+
+// MigratePlaybook converts the playbook file in the directory to the current version
+// playbook.ApiVersion, comments are kept. It returns false if the file is already current.
+// Included playbooks are not converted.
+func MigratePlaybook(directory string) (bool, error) {
+	playbookFile := filepath.Join(directory, PlaybookFileName)
+	data, err := os.ReadFile(playbookFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, knownerror.NewKnownError("File %s doesn't exist", playbookFile)
+		}
+		return false, err
+	}
+
+	converted, err := playbook.Convert(data, playbook.ApiVersion)
+	if err != nil {
+		return false, knownerror.NewKnownError("%s: %s", playbookFile, err)
+	}
+
+	if bytes.Equal(converted, data) {
+		return false, nil
+	}
+
+	// the converted playbook needs to be valid, before the file is replaced
+	_, err = playbook.Decode(converted, playbookFile)
+	if err != nil {
+		return false, err
+	}
+
+	stat, err := os.Stat(playbookFile)
+	if err != nil {
+		return false, err
+	}
+
+	return true, os.WriteFile(playbookFile, converted, stat.Mode().Perm())
+}
@@ -0,0 +1,228 @@
+package execution
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gprossliner/kustomizepb/playbook"
+)
+
+// The status of a component in the report
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusReady   = "ready"
+	StatusSkipped = "skipped"
+	StatusFailed  = "failed"
+)
+
+// Report collects the results of the components from the events of Run.Run
+type Report struct {
+	Components []*ComponentReport
+	Summary    *RunSummary
+
+	// Started is the time of the first event
+	Started time.Time
+
+	// Err is the error of the run
+	Err error
+
+	byName map[string]*ComponentReport
+}
+
+// ComponentReport is the result of a single component
+type ComponentReport struct {
+	Name    string
+	Cluster string
+	Status  string
+
+	// ApplyAttempts is the number of attempts to apply the component, including the successful one
+	ApplyAttempts int
+
+	// TimeToApply and TimeToReady are the times from the start of the component until it has
+	// been applied, and until it's ready
+	TimeToApply time.Duration
+	TimeToReady time.Duration
+
+	// Elapsed is the time the component has been processed
+	Elapsed time.Duration
+
+	// Reason is why the component is skipped, or the last reason it was not ready
+	Reason string
+
+	// Err is the error of a failed component
+	Err error
+}
+
+// NewReport creates a report of the components of the playbook, which are pending until their events are added
+func NewReport(pb *playbook.Playbook) *Report {
+	r := &Report{byName: map[string]*ComponentReport{}}
+	for _, c := range pb.Components {
+		r.component(c.Name, c.Cluster)
+	}
+
+	return r
+}
+
+// Abort marks all pending components as failed by the error, if the run could not be started,
+// like if the prerequisites are not fulfilled
+func (r *Report) Abort(err error) {
+	r.Err = err
+	for _, cr := range r.Components {
+		if cr.Status == StatusPending {
+			cr.Status = StatusFailed
+			cr.Err = err
+		}
+	}
+}
+
+// component returns the report of the component, it's added if it's unknown
+func (r *Report) component(name string, cluster string) *ComponentReport {
+	if cr, ok := r.byName[name]; ok {
+		return cr
+	}
+
+	cr := &ComponentReport{Name: name, Cluster: cluster, Status: StatusPending}
+	r.Components = append(r.Components, cr)
+	r.byName[name] = cr
+	return cr
+}
+
+// Add updates the report by the event
+func (r *Report) Add(ev RunEvent) {
+	if r.Started.IsZero() {
+		r.Started = ev.Time
+	}
+
+	if ev.ID == EV_RunFinished {
+		r.Summary = ev.Summary
+		r.Err = ev.Err
+		return
+	}
+
+	if ev.Component == nil {
+		return
+	}
+
+	cr := r.component(ev.Component.Name, ev.Component.Cluster)
+	cr.Elapsed = ev.Elapsed
+
+	switch ev.ID {
+	case EV_ComponentStarted:
+		cr.Status = StatusRunning
+
+	case EV_ComponentSkipped:
+		cr.Status = StatusSkipped
+		cr.Reason = ev.Reason
+
+	case EV_ComponentApplyRetry:
+		cr.ApplyAttempts = ev.Attempt
+
+	case EV_ComponentApplied:
+		cr.ApplyAttempts = ev.Attempt
+		cr.TimeToApply = ev.Elapsed
+
+	case EV_ReadinessNotFulfilled:
+		cr.Reason = ev.Reason
+
+	case EV_ComponentReady:
+		cr.Status = StatusReady
+		cr.Reason = ""
+		cr.TimeToReady = ev.Elapsed
+
+	case EV_ComponentFailed:
+		cr.Status = StatusFailed
+		cr.Err = ev.Err
+	}
+}
+
+// junitTestSuites is the root element of a JUnit XML report
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr,omitempty"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure"`
+	Skipped   *junitMessage `xml:"skipped"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Body    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the report as JUnit XML, with a testcase for every component. Failed components
+// are failures with the last reason the component was not ready, skipped and pending components are skipped.
+func (r *Report) WriteJUnit(w io.Writer, name string) error {
+	suite := junitTestSuite{Name: name, Tests: len(r.Components), Time: seconds(0)}
+	if r.Summary != nil {
+		suite.Time = seconds(r.Summary.Duration)
+	}
+	if !r.Started.IsZero() {
+		suite.Timestamp = r.Started.UTC().Format(time.RFC3339)
+	}
+
+	for _, cr := range r.Components {
+		tc := junitTestCase{Name: cr.Name, ClassName: name, Time: seconds(cr.Elapsed)}
+		if cr.Cluster != "" {
+			tc.ClassName = name + "." + cr.Cluster
+		}
+
+		switch cr.Status {
+		case StatusFailed:
+			suite.Failures++
+			f := &junitMessage{Body: cr.Reason}
+			if cr.Err != nil {
+				f.Message = cr.Err.Error()
+			}
+			if f.Body == "" {
+				f.Body = f.Message
+			}
+			tc.Failure = f
+
+		case StatusSkipped:
+			suite.Skipped++
+			tc.Skipped = &junitMessage{Message: cr.Reason}
+
+		case StatusPending, StatusRunning:
+			suite.Skipped++
+			tc.Skipped = &junitMessage{Message: "not processed, because a component before has failed"}
+		}
+
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitTestSuites{Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// seconds formats the duration as seconds, like JUnit
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
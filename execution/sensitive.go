@@ -0,0 +1,219 @@
+package execution
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gprossliner/kustomizepb/knownerror"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+const (
+	// placeholderPrefix is the prefix of all placeholders, to find placeholders which have not been replaced
+	placeholderPrefix = "kustomizepb-sensitive-"
+
+	// placeholderKeyFileName is the name of the file of the placeholder key, in the user config directory
+	placeholderKeyFileName = "placeholder.key"
+)
+
+// masking replaces the values of sensitive variables with placeholders, so they are
+// not written to the temporary kustomization file. The placeholders are replaced with the
+// values in the rendered manifest, which is kept in memory.
+//
+// The placeholders are derived from the name and the value, so the name hash suffix of
+// generators changes with the value, and consumers are rolled if a secret is rotated. They are
+// keyed by a random key of the installation, see placeholderKey, so the file doesn't reveal a
+// hash of the value. The suffix therefore differs between machines with different keys.
+type masking struct {
+
+	// key is the key of the placeholders, it's read when the first variable is masked
+	key []byte
+
+	// placeholders by variable name
+	placeholders map[string]string
+
+	// replacements of placeholders with values, in plain and base64 encoding
+	replacements [][2][]byte
+}
+
+func newMasking(vars map[string]string, sensitive map[string]bool) (*masking, error) {
+	m := &masking{placeholders: map[string]string{}}
+
+	for name := range sensitive {
+		if value, ok := vars[name]; ok {
+			if err := m.add(name, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// add masks the variable, like for outputs read from Secrets
+func (m *masking) add(name string, value string) error {
+	if m.key == nil {
+		key, err := placeholderKey()
+		if err != nil {
+			return err
+		}
+		m.key = key
+	}
+
+	placeholder := m.placeholderFor(name, value)
+	m.placeholders[name] = placeholder
+
+	b64 := base64.StdEncoding.EncodeToString
+	m.replacements = append(m.replacements,
+		[2][]byte{[]byte(placeholder), []byte(value)},
+		[2][]byte{[]byte(b64([]byte(placeholder))), []byte(b64([]byte(value)))},
+	)
+
+	return nil
+}
+
+// placeholderFor derives the placeholder of the variable from its name and value
+func (m *masking) placeholderFor(name string, value string) string {
+	mac := hmac.New(sha256.New, m.key)
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return placeholderPrefix + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// placeholderKey returns the key of the placeholders, which is created in the user config
+// directory when it's used for the first time
+func placeholderKey() ([]byte, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, knownerror.NewKnownError("Unable to find the directory of the placeholder key: %s", err)
+	}
+
+	file := filepath.Join(dir, "kustomizepb", placeholderKeyFileName)
+	key, err := os.ReadFile(file)
+	if err == nil {
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, knownerror.NewKnownError("Unable to read the placeholder key: %s", err)
+	}
+
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return nil, knownerror.NewKnownError("Unable to create the placeholder key: %s", err)
+	}
+
+	// another process may have created the key in the meantime
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return os.ReadFile(file)
+	}
+	if err != nil {
+		return nil, knownerror.NewKnownError("Unable to create the placeholder key: %s", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(key); err != nil {
+		return nil, knownerror.NewKnownError("Unable to write the placeholder key: %s", err)
+	}
+
+	return key, nil
+}
+
+// maskedVars returns vars with the sensitive values replaced by placeholders
+func (m *masking) maskedVars(vars map[string]string) map[string]string {
+	res := map[string]string{}
+	for k, v := range vars {
+		if p, ok := m.placeholders[k]; ok {
+			res[k] = p
+		} else {
+			res[k] = v
+		}
+	}
+
+	return res
+}
+
+// unmask replaces the placeholders in the manifest with the values. Placeholders can only be
+// replaced if they are plain, or base64 encoded as a whole. If a placeholder is left, like
+// when it's base64 encoded as part of a larger value, an error is returned.
+func (m *masking) unmask(manifest []byte) ([]byte, error) {
+	for _, r := range m.replacements {
+		manifest = bytes.ReplaceAll(manifest, r[0], r[1])
+	}
+
+	if len(m.placeholders) == 0 {
+		return manifest, nil
+	}
+
+	if err := m.checkUnmasked(manifest); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// checkUnmasked returns an error if the manifest contains a placeholder, plain or in base64 encoded values
+func (m *masking) checkUnmasked(manifest []byte) error {
+	dec := yaml3.NewDecoder(bytes.NewReader(manifest))
+	for {
+		var doc yaml3.Node
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return knownerror.NewKnownError("Error parsing the rendered manifest: %s", err)
+		}
+
+		if err := m.checkNode(&doc); err != nil {
+			return err
+		}
+	}
+}
+
+func (m *masking) checkNode(n *yaml3.Node) error {
+	if n.Kind == yaml3.ScalarNode {
+		value := n.Value
+		if decoded, err := base64.StdEncoding.DecodeString(value); err == nil {
+			value += "\n" + string(decoded)
+		}
+
+		if strings.Contains(value, placeholderPrefix) {
+			return knownerror.NewKnownError("The sensitive variable %s is used in a value kustomize has encoded (line %d of the rendered manifest), "+
+				"sensitive variables can only be used as whole values", m.nameIn(value), n.Line)
+		}
+	}
+
+	for _, c := range n.Content {
+		if err := m.checkNode(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// nameIn returns the name of the variable whose placeholder is in the value
+func (m *masking) nameIn(value string) string {
+	for name, p := range m.placeholders {
+		if strings.Contains(value, p) {
+			return name
+		}
+	}
+
+	return "(unknown)"
+}
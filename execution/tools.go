@@ -0,0 +1,137 @@
+package execution
+
+import (
+	"bytes"
+	"encoding/json"
+	"os/exec"
+	"regexp"
+
+	"github.com/gprossliner/kustomizepb/knownerror"
+	"github.com/gprossliner/kustomizepb/playbook"
+)
+
+const (
+	DefaultKustomizeBinary = "kustomize"
+	DefaultKubectlBinary   = "kubectl"
+
+	// EnvKustomizeBinary and EnvKubectlBinary can be used to override the binaries
+	EnvKustomizeBinary = "KUSTOMIZEPB_KUSTOMIZE"
+	EnvKubectlBinary   = "KUSTOMIZEPB_KUBECTL"
+)
+
+// Tool is a local binary used by the execution
+type Tool struct {
+	Name    string
+	Path    string
+	Version string
+}
+
+var kustomizeVersionRegexp = regexp.MustCompile(`v?\d+\.\d+(\.\d+)?`)
+
+// DetectTools resolves kustomize and kubectl, and checks their versions against the tools of the playbook.
+// Empty binaries default to DefaultKustomizeBinary and DefaultKubectlBinary.
+func DetectTools(pb *playbook.Playbook, kustomizeBinary string, kubectlBinary string) (*Tool, *Tool, error) {
+	if kustomizeBinary == "" {
+		kustomizeBinary = DefaultKustomizeBinary
+	}
+	if kubectlBinary == "" {
+		kubectlBinary = DefaultKubectlBinary
+	}
+
+	kustomize, err := detectTool("kustomize", kustomizeBinary, pb.Tools.Kustomize, kustomizeVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kubectl, err := detectTool("kubectl", kubectlBinary, pb.Tools.Kubectl, kubectlVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return kustomize, kubectl, nil
+}
+
+// detectTool resolves the binary, and checks its version against the constraint
+func detectTool(name string, binary string, constraint playbook.VersionConstraint, getVersion func(path string) (string, error)) (*Tool, error) {
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		return nil, knownerror.NewKnownError("%s binary '%s' not found: %s", name, binary, err)
+	}
+
+	version, err := getVersion(path)
+	if err != nil {
+		return nil, knownerror.NewKnownError("Unable to get the version of %s (%s): %s", name, path, err)
+	}
+
+	if constraint != "" {
+		ok, err := constraint.Check(version)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			return nil, knownerror.NewKnownError("%s %s (%s) doesn't satisfy the required version '%s'", name, version, path, constraint)
+		}
+	}
+
+	return &Tool{Name: name, Path: path, Version: version}, nil
+}
+
+func kustomizeVersion(path string) (string, error) {
+	out, err := exec.Command(path, "version").Output()
+	if err != nil {
+		return "", err
+	}
+
+	return parseKustomizeVersion(out)
+}
+
+// parseKustomizeVersion supports "v5.0.0" and "{Version:kustomize/v4.5.7 GitCommit:...}"
+func parseKustomizeVersion(out []byte) (string, error) {
+	v := kustomizeVersionRegexp.Find(out)
+	if v == nil {
+		return "", knownerror.NewKnownError("unexpected output '%s'", bytes.TrimSpace(out))
+	}
+
+	return string(v), nil
+}
+
+// loadRestrictorArgs returns the arguments of kustomize build to load files outside of the
+// kustomization directory, the flag has been renamed in kustomize v4
+func loadRestrictorArgs(kustomize *Tool) []string {
+	if kustomize.Version != "" {
+		if v3, err := playbook.VersionConstraint("< 4.0").Check(kustomize.Version); err == nil && v3 {
+			return []string{"--load_restrictor", "LoadRestrictionsNone"}
+		}
+	}
+
+	return []string{"--load-restrictor", "LoadRestrictionsNone"}
+}
+
+func kubectlVersion(path string) (string, error) {
+	out, err := exec.Command(path, "version", "--client", "-o", "json").Output()
+	if err != nil {
+		return "", err
+	}
+
+	return parseKubectlVersion(out)
+}
+
+func parseKubectlVersion(out []byte) (string, error) {
+	var v struct {
+		ClientVersion struct {
+			GitVersion string `json:"gitVersion"`
+		} `json:"clientVersion"`
+	}
+
+	err := json.Unmarshal(out, &v)
+	if err != nil {
+		return "", err
+	}
+
+	if v.ClientVersion.GitVersion == "" {
+		return "", knownerror.NewKnownError("unexpected output '%s'", bytes.TrimSpace(out))
+	}
+
+	return v.ClientVersion.GitVersion, nil
+}
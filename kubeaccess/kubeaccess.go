@@ -3,6 +3,7 @@ package kubeaccess
 import (
 	"context"
 
+	corev1 "k8s.io/api/core/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -14,6 +15,11 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 )
 
+const (
+	defaultStorageClassAnnotation     = "storageclass.kubernetes.io/is-default-class"
+	betaDefaultStorageClassAnnotation = "storageclass.beta.kubernetes.io/is-default-class"
+)
+
 // Options are the processed options for the caller
 type KubeAccess struct {
 	KubeRest       *rest.Config
@@ -78,6 +84,103 @@ func (ka *KubeAccess) HasNode(ctx context.Context, name string) (bool, error) {
 	return true, nil
 }
 
+// KubeSystemUID returns the UID of the kube-system namespace, which identifies the cluster
+func (ka *KubeAccess) KubeSystemUID(ctx context.Context) (string, error) {
+	ns, err := ka.KubeClientset.CoreV1().Namespaces().Get(ctx, "kube-system", metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	return string(ns.UID), nil
+}
+
+// GetConfigMapLabels returns the labels of a ConfigMap, and false if it doesn't exist
+func (ka *KubeAccess) GetConfigMapLabels(ctx context.Context, namespace, name string) (map[string]string, bool, error) {
+	cm, err := ka.KubeClientset.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			return nil, false, nil
+		}
+
+		return nil, false, err
+	}
+
+	return cm.Labels, true, nil
+}
+
+// GetSecretData returns the decoded data of a Secret
+func (ka *KubeAccess) GetSecretData(ctx context.Context, namespace, name string) (map[string]string, error) {
+	secret, err := ka.KubeClientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]string{}
+	for k, v := range secret.Data {
+		data[k] = string(v)
+	}
+
+	return data, nil
+}
+
+// GetConfigMapData returns the data of a ConfigMap
+func (ka *KubeAccess) GetConfigMapData(ctx context.Context, namespace, name string) (map[string]string, error) {
+	cm, err := ka.KubeClientset.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return cm.Data, nil
+}
+
+// ListNodes returns all nodes matching the label selector, which may be empty
+func (ka *KubeAccess) ListNodes(ctx context.Context, selector string) ([]corev1.Node, error) {
+	nodes, err := ka.KubeClientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+
+	return nodes.Items, nil
+}
+
+// IsNodeReady returns true if the Ready condition of the node is True
+func IsNodeReady(node *corev1.Node) bool {
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+// ServerVersion returns the git version of the API server, like v1.25.3
+func (ka *KubeAccess) ServerVersion() (string, error) {
+	info, err := ka.KubeDiscClient.ServerVersion()
+	if err != nil {
+		return "", err
+	}
+
+	return info.GitVersion, nil
+}
+
+// GetDefaultStorageClasses returns the names of all StorageClasses annotated as default
+func (ka *KubeAccess) GetDefaultStorageClasses(ctx context.Context) ([]string, error) {
+	scs, err := ka.KubeClientset.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, sc := range scs.Items {
+		if sc.Annotations[defaultStorageClassAnnotation] == "true" || sc.Annotations[betaDefaultStorageClassAnnotation] == "true" {
+			names = append(names, sc.Name)
+		}
+	}
+
+	return names, nil
+}
+
 func (ka *KubeAccess) GetObject(ctx context.Context, gvr schema.GroupVersionResource, namespace string, name string) (*unstructured.Unstructured, error) {
 
 	var ri dynamic.ResourceInterface
@@ -98,6 +201,27 @@ func (ka *KubeAccess) GetObject(ctx context.Context, gvr schema.GroupVersionReso
 	return obj, nil
 }
 
+// ListObjects lists the objects matching the label selector, namespace may be empty for cluster scoped resources
+func (ka *KubeAccess) ListObjects(ctx context.Context, gvr schema.GroupVersionResource, namespace string, selector string) ([]unstructured.Unstructured, error) {
+
+	var ri dynamic.ResourceInterface
+
+	h := ka.KubeDynClient.Resource(gvr)
+
+	if namespace != "" {
+		ri = h.Namespace(namespace)
+	} else {
+		ri = h
+	}
+
+	list, err := ri.List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+
+	return list.Items, nil
+}
+
 // GroupVersionResourceFromApiVersion tries to find the resource based on GVK.
 // If no matching resource can be found, nil is returned
 func (ka *KubeAccess) TryGetGroupVersionResource(apiVersion string, kind string) (*schema.GroupVersionResource, error) {
@@ -132,6 +256,39 @@ func (ka *KubeAccess) TryGetGroupVersionResource(apiVersion string, kind string)
 	return nil, nil
 }
 
+// TryGetPreferredGroupVersionResource finds the resource of the kind in the preferred version of the group.
+// If no matching resource can be found, nil is returned
+func (ka *KubeAccess) TryGetPreferredGroupVersionResource(group string, kind string) (*schema.GroupVersionResource, error) {
+
+	lists, err := ka.KubeDiscClient.ServerPreferredResources()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			return nil, err
+		}
+
+		if gv.Group != group {
+			continue
+		}
+
+		for _, resource := range list.APIResources {
+			if resource.Kind == kind {
+				return &schema.GroupVersionResource{
+					Group:    gv.Group,
+					Version:  gv.Version,
+					Resource: resource.Name,
+				}, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
 func (ka *KubeAccess) HasCustomResourceName(ctx context.Context, customResourceName string) (bool, error) {
 	// get crds
 	crds, err := ka.KubeDynClient.
@@ -102,6 +102,33 @@ func TestGetNodeObject(t *testing.T) {
 
 }
 
+func TestClusterInfo(t *testing.T) {
+	ctx := context.Background()
+
+	nodes, err := ka.ListNodes(ctx, "")
+	assert.NoError(t, err)
+	assert.Len(t, nodes, 1)
+	assert.Equal(t, knownNodeName, nodes[0].Name)
+	assert.True(t, IsNodeReady(&nodes[0]))
+
+	nodes, err = ka.ListNodes(ctx, "kubernetes.io/hostname="+knownNodeName)
+	assert.NoError(t, err)
+	assert.Len(t, nodes, 1)
+
+	nodes, err = ka.ListNodes(ctx, "doesnotexist=true")
+	assert.NoError(t, err)
+	assert.Len(t, nodes, 0)
+
+	v, err := ka.ServerVersion()
+	assert.NoError(t, err)
+	assert.Regexp(t, "^v1\\.", v)
+
+	// kind installs the local-path provisioner as default
+	scs, err := ka.GetDefaultStorageClasses(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"standard"}, scs)
+}
+
 func TestServiceReady(t *testing.T) {
 	var err error
 	ctx := context.Background()
@@ -2,9 +2,15 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"k8s.io/client-go/util/homedir"
 
@@ -12,7 +18,9 @@ import (
 	"github.com/gprossliner/kustomizepb/knownerror"
 	"github.com/gprossliner/kustomizepb/kubeaccess"
 	"github.com/gprossliner/kustomizepb/output"
-	"github.com/joho/godotenv"
+	"github.com/gprossliner/kustomizepb/playbook"
+	"github.com/gprossliner/kustomizepb/variables"
+	"gopkg.in/yaml.v2"
 )
 
 func main() {
@@ -29,31 +37,247 @@ func main() {
 	}
 }
 
+const (
+	cmdApply    = "apply"
+	cmdIdentity = "identity"
+	cmdVars     = "vars"
+	cmdEnv      = "env"
+	cmdPlan     = "plan"
+	cmdValidate = "validate"
+	cmdSchema   = "schema"
+	cmdMigrate  = "migrate"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// commands are the subcommands, and if they need a directory argument
+var commands = map[string]bool{
+	cmdApply:    true,
+	cmdIdentity: false,
+	cmdVars:     true,
+	cmdEnv:      true,
+	cmdPlan:     true,
+	cmdValidate: true,
+	cmdSchema:   false,
+	cmdMigrate:  true,
+}
+
+// stringSlice is a flag which can be given multiple times
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
 func themain(ctx context.Context) error {
 
-	var kubeconfig, kubecontext, envfile, knownNode string
+	var kubeconfig, kubecontext, knownNode, kustomizeBinary, kubectlBinary, ageKeyFile, profile, outputFormat, junitFile string
+	var envfiles, sets, secretVars, configMapVars, clusters stringSlice
 
 	flag.StringVar(&kubeconfig, "kubeconfig", filepath.Join(homedir.HomeDir(), ".kube", "config"), "(optional) absolute path to the kubeconfig file")
 	flag.StringVar(&kubecontext, "context", "", "The name of the kubeconfig context to use")
-	flag.StringVar(&envfile, "envfile", "", "file for envsubst")
-	flag.StringVar(&knownNode, "knownNode", "", "specify the name of a cluster node that must exist")
+	flag.Var(&clusters, "cluster", "override the context of a cluster of the playbook as NAME=CONTEXT, can be given multiple times")
+	flag.StringVar(&profile, "profile", "", "the name of the profile of the playbook to apply")
+	flag.Var(&envfiles, "envfile", "file for envsubst, can be given multiple times, later files take precedence")
+	flag.StringVar(&ageKeyFile, "age-key-file", "", "file with age identities to decrypt SOPS or age encrypted envfiles, defaults to $SOPS_AGE_KEY_FILE")
+	flag.Var(&secretVars, "secret-vars", "read variables from the keys of a Secret given as namespace/name[@context], can be given multiple times")
+	flag.Var(&configMapVars, "configmap-vars", "read variables from the keys of a ConfigMap given as namespace/name[@context], can be given multiple times")
+	flag.Var(&sets, "set", "set a variable for envsubst as KEY=VALUE, takes precedence over envfiles and the environment")
+	flag.StringVar(&kustomizeBinary, "kustomize", envOrDefault(execution.EnvKustomizeBinary, execution.DefaultKustomizeBinary), "name or path of the kustomize binary")
+	flag.StringVar(&kubectlBinary, "kubectl", envOrDefault(execution.EnvKubectlBinary, execution.DefaultKubectlBinary), "name or path of the kubectl binary")
+	flag.StringVar(&outputFormat, "output", outputText, "the format of the events of applying the playbook, text or json (one object per line on stdout)")
+	flag.StringVar(&junitFile, "junit", "", "(optional) write the results of the components as JUnit XML to the file")
+	flag.StringVar(&knownNode, "knownNode", "", "(deprecated, use a nodes prerequisite) specify the name of a cluster node that must exist")
+
+	flag.Usage = usage
+
+	// the command is optional and defaults to apply, flags may be given before and after it
+	command := cmdApply
+	flag.CommandLine.Parse(os.Args[1:])
+	if _, isCommand := commands[flag.Arg(0)]; isCommand {
+		command = flag.Arg(0)
+		flag.CommandLine.Parse(flag.Args()[1:])
+	}
 
-	flag.Parse()
-	if flag.NArg() == 0 {
+	if commands[command] && flag.NArg() == 0 {
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	ka, err := kubeaccess.NewKubeAccess(kubeconfig, kubecontext)
+	// flags after the directory are not parsed by the flag package
+	maxArgs := 0
+	if commands[command] {
+		maxArgs = 1
+	}
+	if flag.NArg() > maxArgs {
+		return knownerror.NewKnownError("Unexpected arguments '%s', flags need to be given before the directory", strings.Join(flag.Args()[maxArgs:], " "))
+	}
+
+	// stdout is reserved for the events, all messages are written to stderr
+	switch outputFormat {
+	case outputText:
+	case outputJSON:
+		output.Writer = os.Stderr
+	default:
+		return knownerror.NewKnownError("Invalid output '%s', must be %s or %s", outputFormat, outputText, outputJSON)
+	}
+
+	// the variables referenced by the playbook are read from the environment
+	var referenced []playbook.VarUsage
+	var pb *playbook.Playbook
+	if command == cmdValidate {
+		return validatePlaybook(flag.Arg(0), profile)
+	}
+
+	if command == cmdSchema {
+		return printSchema()
+	}
+
+	if command == cmdMigrate {
+		return migratePlaybook(flag.Arg(0))
+	}
+
+	if commands[command] {
+		var err error
+		pb, err = execution.LoadPlaybook(flag.Arg(0), profile)
+		if err != nil {
+			return err
+		}
+
+		for _, w := range pb.Warnings() {
+			output.WarningF("%s", w)
+		}
+
+		if command == cmdPlan {
+			return printPlan(pb)
+		}
+
+		referenced, err = pb.ReferencedVars()
+		if err != nil {
+			return err
+		}
+	}
+
+	// check the local tools, before the variables are read and anything touches the cluster
+	var kustomize, kubectl *execution.Tool
+	if command == cmdApply {
+		var err error
+		kustomize, kubectl, err = execution.DetectTools(pb, kustomizeBinary, kubectlBinary)
+		if err != nil {
+			return err
+		}
+	}
+
+	// KubeAccess is created lazily, so commands like vars only need a cluster if there are varSources
+	kubeAccesses := map[string]*kubeaccess.KubeAccess{}
+	kubeAccessFor := func(kctx string) (*kubeaccess.KubeAccess, error) {
+		if kctx == "" {
+			kctx = kubecontext
+		}
+
+		if ka, ok := kubeAccesses[kctx]; ok {
+			return ka, nil
+		}
+
+		ka, err := kubeaccess.NewKubeAccess(kubeconfig, kctx)
+		if err != nil {
+			return nil, err
+		}
+
+		kubeAccesses[kctx] = ka
+		return ka, nil
+	}
+
+	varOptions := variables.Options{EnvFiles: envfiles, Sets: sets, KubeAccess: kubeAccessFor, AgeKeyFile: ageKeyFile}
+	for _, v := range referenced {
+		varOptions.Referenced = append(varOptions.Referenced, v.Name)
+	}
+
+	// declared variables are read from the environment too, like for exec conditions
+	if pb != nil {
+		for _, d := range pb.Vars {
+			varOptions.Referenced = append(varOptions.Referenced, d.Name)
+		}
+		varOptions.Sources = append(varOptions.Sources, pb.VarSources...)
+	}
+
+	for _, sv := range secretVars {
+		src, err := variables.ParseSource(sv, true)
+		if err != nil {
+			return err
+		}
+		varOptions.Sources = append(varOptions.Sources, src)
+	}
+
+	for _, cv := range configMapVars {
+		src, err := variables.ParseSource(cv, false)
+		if err != nil {
+			return err
+		}
+		varOptions.Sources = append(varOptions.Sources, src)
+	}
+
+	vars, err := variables.Load(ctx, varOptions)
+	if err != nil {
+		return err
+	}
+
+	// defaults of declared variables have the lowest precedence
+	if pb != nil {
+		for _, d := range pb.Vars {
+			if _, ok := vars[d.Name]; !ok && d.Default != nil {
+				vars.Add(d.Name, *d.Default, variables.SourceDefault)
+			}
+		}
+	}
+
+	switch command {
+	case cmdVars:
+		return printVars(referenced, vars, pb.OutputProducers())
+	case cmdEnv:
+		return printEnv(vars)
+	}
+
+	ka, err := kubeAccessFor("")
 	if err != nil {
 		return err
 	}
 
+	if command == cmdIdentity {
+		return printIdentity(ctx, ka)
+	}
+
+	clusterContexts := map[string]string{}
+	for _, c := range clusters {
+		name, clusterContext, found := strings.Cut(c, "=")
+		if !found || name == "" {
+			return knownerror.NewKnownError("Invalid cluster '%s', must be NAME=CONTEXT", c)
+		}
+		clusterContexts[name] = clusterContext
+	}
+
 	options := &execution.Options{
 		KubeAccess:  ka,
 		KubeConfig:  kubeconfig,
 		KubeContext: kubecontext,
 		Directory:   flag.Arg(0),
+		Profile:     profile,
+		Envs:        vars.Values(),
+		Sensitive:   vars.SensitiveNames(),
+
+		KustomizeBinary: kustomizeBinary,
+		KubectlBinary:   kubectlBinary,
+		Kustomize:       kustomize,
+		Kubectl:         kubectl,
+		ClusterContexts: clusterContexts,
 	}
 
 	// validate knownNode
@@ -64,74 +288,311 @@ func themain(ctx context.Context) error {
 		}
 	}
 
-	// process envfile
-	if envfile != "" {
-		envMap, err := godotenv.Read(envfile)
-		if err != nil {
-			if os.IsNotExist(err) {
-				return knownerror.NewKnownError("envfile %s doesn't exist", envfile)
+	// the report is also written if the run can't be started, so CI shows the components as failed
+	report := execution.NewReport(pb)
+	run, err := execution.LoadRun(ctx, pb, options)
+	if err != nil {
+		if junitFile != "" {
+			report.Abort(err)
+			if jerr := writeJUnit(report, junitFile, flag.Arg(0)); jerr != nil {
+				output.Error(jerr.Error())
 			}
-			return err
 		}
-
-		options.Envs = envMap
+		return err
 	}
 
-	run, err := execution.LoadRun(ctx, options)
-	if err != nil {
-		return err
+	printEvent := printTextEvent
+	if outputFormat == outputJSON {
+		printEvent = jsonEventPrinter()
 	}
 
+	// all events are printed and added to the report, before the result of the run
 	events := make(chan execution.RunEvent)
+	done := make(chan struct{})
 	go func() {
+		for event := range events {
+			printEvent(event)
+			report.Add(event)
+		}
+		close(done)
+	}()
+
+	err = run.Run(ctx, options, events)
+	close(events)
+	<-done
 
-		for {
+	if outputFormat == outputText {
+		if perr := printReport(report); perr != nil {
+			return perr
+		}
+	}
 
-			switch event := <-events; event.ID {
-			case execution.EV_ComponentStarted:
-				output.HeadingF("Processing component '%s'", event.Component.Name)
+	if junitFile != "" {
+		if jerr := writeJUnit(report, junitFile, flag.Arg(0)); jerr != nil {
+			return jerr
+		}
+	}
 
-			case execution.EV_TestApplyConditions:
-				output.InfoF("Testing applyConditions")
+	return err
+}
 
-			case execution.EV_ApplyConditionsNotFulfilled:
-				output.InfoF("applyConditions not fulfilled")
+// printReport prints a table with the result of every component
+func printReport(report *execution.Report) error {
+	fmt.Fprintln(output.Writer)
+	w := tabwriter.NewWriter(output.Writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "COMPONENT\tCLUSTER\tSTATUS\tAPPLY ATTEMPTS\tTIME TO APPLY\tTIME TO READY")
+	for _, c := range report.Components {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", c.Name, c.Cluster, c.Status, c.ApplyAttempts,
+			formatDuration(c.TimeToApply), formatDuration(c.TimeToReady))
+	}
 
-			case execution.EV_ComponentApplying:
-				output.InfoF("Applying component")
+	return w.Flush()
+}
 
-			case execution.EV_ComponentApplyRetry:
-				output.InfoF("Retry applying component")
+// formatDuration formats the duration rounded to 100ms, or empty if it's zero
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
 
-			case execution.EV_TestReadiness:
-				output.InfoF("Testing readiness")
+	return d.Round(100 * time.Millisecond).String()
+}
 
-			case execution.EV_ComponentReady:
-				output.InfoF("Component ready")
-			}
+// writeJUnit writes the report as JUnit XML, the testsuite is named by the playbook directory
+func writeJUnit(report *execution.Report, file string, directory string) error {
+	name := filepath.Base(directory)
+	if abs, err := filepath.Abs(directory); err == nil {
+		name = filepath.Base(abs)
+	}
 
+	f, err := os.Create(file)
+	if err != nil {
+		return knownerror.NewKnownError("Unable to write JUnit report: %s", err)
+	}
+	defer f.Close()
+
+	if err := report.WriteJUnit(f, name); err != nil {
+		return knownerror.NewKnownError("Unable to write JUnit report: %s", err)
+	}
+
+	return f.Close()
+}
+
+// printTextEvent prints the event for humans
+func printTextEvent(event execution.RunEvent) {
+	switch event.ID {
+	case execution.EV_ComponentStarted:
+		output.HeadingF("Processing component '%s'", event.Component.Name)
+
+	case execution.EV_TestApplyConditions:
+		output.InfoF("Testing applyConditions")
+
+	case execution.EV_ApplyConditionsNotFulfilled:
+		output.InfoF("applyConditions not fulfilled: %s", event.Reason)
+
+	case execution.EV_ComponentApplying:
+		output.InfoF("Applying component")
+
+	case execution.EV_ComponentApplied:
+		if event.Output != "" {
+			fmt.Fprintln(output.Writer, event.Output)
 		}
-	}()
 
-	defer close(events)
+	case execution.EV_ComponentApplyRetry:
+		output.InfoF("Retry applying component in %s (attempt %d): %s", event.Backoff, event.Attempt, event.Err)
 
-	err = run.Run(ctx, options, events)
+	case execution.EV_TestReadiness:
+		output.InfoF("Testing readiness")
+
+	case execution.EV_ReadinessNotFulfilled:
+		output.InfoF("Not ready: %s", event.Reason)
+
+	case execution.EV_ComponentReady:
+		output.InfoF("Component ready after %s", event.Elapsed.Round(time.Second))
+
+	case execution.EV_ComponentSkipped:
+		output.InfoF("Component skipped")
+
+	case execution.EV_ComponentFailed:
+		output.Error(fmt.Sprintf("Component failed after %s", event.Elapsed.Round(time.Second)))
+
+	case execution.EV_RunFinished:
+		s := event.Summary
+		output.HeadingF("Finished in %s: %d ready, %d skipped, %d failed, %d pending",
+			s.Duration.Round(time.Second), s.Ready, s.Skipped, s.Failed, s.Pending)
+	}
+}
+
+// jsonEventPrinter returns a function printing the events as JSON objects on stdout, one per line
+func jsonEventPrinter() func(event execution.RunEvent) {
+	enc := json.NewEncoder(os.Stdout)
+	return func(event execution.RunEvent) {
+		if err := enc.Encode(event); err != nil {
+			output.Error(err.Error())
+		}
+	}
+}
+
+// envOrDefault returns the value of the environment variable, or def if it's not set
+func envOrDefault(key string, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+
+	return def
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [command] [flags] directory\n\n", os.Args[0])
+	fmt.Fprintf(out, "Commands:\n")
+	fmt.Fprintf(out, "  apply     apply the playbook in directory (default)\n")
+	fmt.Fprintf(out, "  identity  print the identity of the current cluster\n")
+	fmt.Fprintf(out, "  vars      list the variables referenced by the playbook in directory\n")
+	fmt.Fprintf(out, "  env       print the effective variables and their source\n")
+	fmt.Fprintf(out, "  plan      list the components of the playbook in directory, in the order they are applied\n")
+	fmt.Fprintf(out, "  validate  validate the playbook in directory, without accessing a cluster\n")
+	fmt.Fprintf(out, "  schema    print the JSON Schema of playbooks\n")
+	fmt.Fprintf(out, "  migrate   convert the playbook in directory to the current apiVersion\n\n")
+	fmt.Fprintf(out, "Flags:\n")
+	flag.PrintDefaults()
+}
+
+// printIdentity prints the identity of the cluster, so it can be pinned in the playbook or envfile
+func printIdentity(ctx context.Context, ka *kubeaccess.KubeAccess) error {
+	identity, err := playbook.GetClusterIdentity(ctx, ka)
+	if err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(map[string]any{"clusterIdentity": identity})
 	if err != nil {
 		return err
 	}
 
+	output.HeadingF("Playbook")
+	fmt.Print(string(data))
+
+	output.HeadingF("Envfile")
+	fmt.Printf("%s=%s\n", playbook.EnvClusterUID, identity.KubeSystemUID)
+	fmt.Printf("%s=%s\n", playbook.EnvClusterServer, identity.Server)
+
+	return nil
+}
+
+// printVars lists all variables referenced by the playbook, and if they are provided
+func printVars(referenced []playbook.VarUsage, vars variables.Set, outputs map[string]string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VARIABLE\tSTATUS\tSOURCE\tCOMPONENTS")
+	for _, r := range referenced {
+		status, source := "missing", ""
+		if producer, ok := outputs[r.Name]; ok {
+			status, source = "output", "component "+producer
+		} else if v, ok := vars[r.Name]; ok && v.Source != variables.SourceDefault {
+			status, source = "provided", v.Source
+		} else if ok || r.HasDefault {
+			status = "default"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Name, status, source, strings.Join(r.Components, ", "))
+	}
+
+	return w.Flush()
+}
+
+// validatePlaybook prints all errors of the playbook
+func validatePlaybook(directory string, profile string) error {
+	pb, errs := execution.ValidatePlaybook(directory, profile)
+	if pb != nil {
+		for _, w := range pb.Warnings() {
+			output.WarningF("%s", w)
+		}
+	}
+
+	for _, err := range errs {
+		output.Error(err.Error())
+	}
+
+	if len(errs) > 0 {
+		return knownerror.NewKnownError("The playbook has %d error(s)", len(errs))
+	}
+
+	output.InfoF("The playbook is valid")
+	return nil
+}
+
+// migratePlaybook converts the playbook to the current apiVersion
+func migratePlaybook(directory string) error {
+	migrated, err := execution.MigratePlaybook(directory)
+	if err != nil {
+		return err
+	}
+
+	if !migrated {
+		output.InfoF("The playbook is already %s", playbook.ApiVersion)
+		return nil
+	}
+
+	output.InfoF("The playbook has been converted to %s", playbook.ApiVersion)
+	return nil
+}
+
+// printSchema prints the JSON Schema, like for the completion in editors
+func printSchema() error {
+	data, err := playbook.JSONSchema()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(data))
 	return nil
 }
 
+// printPlan lists the components in the order they are applied, after includes and forEach are expanded
+func printPlan(pb *playbook.Playbook) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "COMPONENT\tCLUSTER\tDEPENDS ON\tVARS")
+	for _, c := range pb.Components {
+		var dependsOn []string
+		for _, d := range c.DependsOn {
+			dependsOn = append(dependsOn, d.Name)
+		}
+
+		var vars []string
+		for k, v := range c.ItemVars {
+			vars = append(vars, k+"="+v)
+		}
+		sort.Strings(vars)
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.Name, c.Cluster, strings.Join(dependsOn, ", "), strings.Join(vars, ", "))
+	}
+
+	return w.Flush()
+}
+
+// printEnv prints the effective variables, sensitive values are redacted
+func printEnv(vars variables.Set) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VARIABLE\tVALUE\tSOURCE")
+	for _, v := range vars.Sorted() {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", v.Name, v.Display(), v.Source)
+	}
+
+	return w.Flush()
+}
+
+// validateKnownNode checks the --knownNode flag, which is equivalent to a nodes prerequisite
 func validateKnownNode(ctx context.Context, nodeName string, options *execution.Options) error {
 
-	hasNode, err := options.KubeAccess.HasNode(ctx, nodeName)
+	ec := options.EvalContext()
+	nc := &playbook.NodesCondition{Name: nodeName}
+	hasNode, err := nc.IsFulfilled(ctx, ec)
 	if err != nil {
 		return err
 	}
 
 	if !hasNode {
-		return knownerror.NewKnownError("The known Node %s was not found", nodeName)
+		return knownerror.NewKnownError("The known Node %s was not found or is not Ready", nodeName)
 	}
 
 	return nil
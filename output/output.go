@@ -1,6 +1,13 @@
 package output
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Writer receives the messages, it's set to os.Stderr if stdout is used for machine-readable output
+var Writer io.Writer = os.Stdout
 
 const (
 	reset  = "\033[0m"
@@ -17,15 +24,15 @@ const (
 )
 
 func outF(col, s string, arg ...any) {
-	fmt.Print(col)
-	fmt.Printf(s, arg...)
-	fmt.Println(reset)
+	fmt.Fprint(Writer, col)
+	fmt.Fprintf(Writer, s, arg...)
+	fmt.Fprintln(Writer, reset)
 }
 
 func out(col, s string) {
-	fmt.Print(col)
-	fmt.Print(s)
-	fmt.Println(reset)
+	fmt.Fprint(Writer, col)
+	fmt.Fprint(Writer, s)
+	fmt.Fprintln(Writer, reset)
 }
 
 func HeadingF(s string, arg ...any) {
@@ -36,6 +43,10 @@ func InfoF(s string, arg ...any) {
 	outF(cyan, s, arg...)
 }
 
+func WarningF(s string, arg ...any) {
+	outF(yellow, s, arg...)
+}
+
 func Error(s string) {
 	out(red, s)
 }
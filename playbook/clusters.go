@@ -0,0 +1,95 @@
+package playbook
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/gprossliner/kustomizepb/knownerror"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// ForCluster returns a copy of the context for the cluster with the name of the clusters map.
+// If the name is empty, the context itself is returned.
+func (ec *EvalContext) ForCluster(ctx context.Context, name string) (*EvalContext, error) {
+	if name == "" {
+		return ec, nil
+	}
+
+	if ec.ClusterAccess == nil {
+		return nil, knownerror.NewKnownError("Cluster '%s' is not defined", name)
+	}
+
+	ca, err := ec.ClusterAccess(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	res := *ec
+	res.KubeAccess = ca.KubeAccess
+	res.KubeConfig = ca.KubeConfig
+	res.KubeContext = ca.KubeContext
+	res.Reason = ""
+
+	return &res, nil
+}
+
+// validateClusters checks the names of the clusters, and that all referenced clusters are defined
+func (pb *Playbook) validateClusters() []error {
+	var errs []error
+
+	for name := range pb.Clusters {
+		if verrs := validation.IsDNS1123Label(name); len(verrs) > 0 {
+			errs = append(errs, pb.positions["clusters"].errorf("Invalid cluster name '%s': %s", name, strings.Join(verrs, "/")))
+		}
+	}
+
+	validateCluster := func(name string, user string, pos Position) {
+		if _, ok := pb.Clusters[name]; name != "" && !ok {
+			errs = append(errs, pos.errorf("Cluster '%s' of %s is not defined", name, user))
+		}
+	}
+
+	validateConditions := func(cs ConditionSlice, user string) {
+		for _, c := range cs {
+			validateCluster(c.Cluster, user, c.pos)
+		}
+	}
+
+	validateConditions(pb.Prerequisites, PrerequisitesScope)
+	for _, c := range pb.Components {
+		user := "component '" + c.Name + "'"
+		validateCluster(c.Cluster, user, c.pos)
+		validateConditions(c.ReadinessConditions, user)
+		validateConditions(c.ApplyConditions, user)
+	}
+
+	return errs
+}
+
+// ReferencedClusters returns the sorted names of the clusters which are used by the components and conditions
+func (pb *Playbook) ReferencedClusters() []string {
+	names := map[string]bool{}
+	addConditions := func(cs ConditionSlice) {
+		for _, c := range cs {
+			names[c.Cluster] = true
+		}
+	}
+
+	addConditions(pb.Prerequisites)
+	for _, c := range pb.Components {
+		names[c.Cluster] = true
+		addConditions(c.ReadinessConditions)
+		addConditions(c.ApplyConditions)
+	}
+
+	var res []string
+	for name := range names {
+		if name != "" {
+			res = append(res, name)
+		}
+	}
+	sort.Strings(res)
+
+	return res
+}
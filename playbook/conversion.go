@@ -0,0 +1,250 @@
+package playbook
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/gprossliner/kustomizepb/knownerror"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// conversion converts the yaml of a playbook version to the hub version ApiVersion, and back.
+// The types of this package are the hub, every other version is converted to the hub when
+// the playbook is decoded. The nodes are converted in place, and the changes are recorded
+// as edits of the text, so Convert keeps the formatting of the file.
+type conversion struct {
+	toHub   func(root *yaml3.Node, edits *textEdits) error
+	fromHub func(root *yaml3.Node, edits *textEdits) error
+
+	// deprecated versions are still read, with a warning
+	deprecated bool
+
+	// schemaObjects are the types which are written as objects in the version, and as strings
+	// in the hub, for the JSON Schema of the version
+	schemaObjects map[reflect.Type]bool
+}
+
+// conversions are the versions besides the hub which can be read
+var conversions = map[string]conversion{
+	ApiVersionV1beta1: {
+		toHub:         v1beta1ToV1,
+		fromHub:       v1ToV1beta1,
+		deprecated:    true,
+		schemaObjects: map[reflect.Type]bool{reflect.TypeOf(DependsSpec{}): true},
+	},
+}
+
+// ApiVersions returns all versions which can be read, the hub version ApiVersion first
+func ApiVersions() []string {
+	return append([]string{ApiVersion}, sortedKeys(conversions)...)
+}
+
+// Convert converts the playbook to the version. Only the converted parts of the text are changed,
+// so comments and the formatting are kept. The playbook is not validated.
+func Convert(data []byte, version string) ([]byte, error) {
+	var doc yaml3.Node
+	if err := yaml3.Unmarshal(data, &doc); err != nil {
+		return nil, knownerror.NewKnownError("Error parsing yaml: %s", err)
+	}
+
+	if len(doc.Content) == 0 {
+		return data, nil
+	}
+
+	root := doc.Content[0]
+	from := apiVersionNode(root)
+	if from == nil {
+		return nil, knownerror.NewKnownError("The playbook has no apiVersion")
+	}
+
+	if from.Value == version {
+		return data, nil
+	}
+
+	edits := newTextEdits(data)
+	original := *from
+	if err := convertToHub(root, edits); err != nil {
+		return nil, err
+	}
+
+	if version != ApiVersion {
+		c, ok := conversions[version]
+		if !ok {
+			return nil, knownerror.NewKnownError("Unknown apiVersion '%s', must be one of %s", version, strings.Join(ApiVersions(), ", "))
+		}
+
+		if err := c.fromHub(root, edits); err != nil {
+			return nil, err
+		}
+	}
+
+	edits.replaceScalar(&original, version)
+	return edits.apply()
+}
+
+// convertToHub converts the playbook to the hub version, if it's a version with a conversion
+func convertToHub(root *yaml3.Node, edits *textEdits) error {
+	n := apiVersionNode(root)
+	if n == nil {
+		return nil
+	}
+
+	c, ok := conversions[n.Value]
+	if !ok {
+		return nil
+	}
+
+	if err := c.toHub(root, edits); err != nil {
+		return err
+	}
+
+	n.Value = ApiVersion
+	return nil
+}
+
+// apiVersionNode returns the value node of the apiVersion, or nil
+func apiVersionNode(root *yaml3.Node) *yaml3.Node {
+	n := mappingValue(root, "apiVersion")
+	if n == nil || n.Kind != yaml3.ScalarNode {
+		return nil
+	}
+
+	return n
+}
+
+// componentNodes returns the mapping nodes of the components
+func componentNodes(root *yaml3.Node) []*yaml3.Node {
+	n := mappingValue(root, "components")
+	if n == nil || n.Kind != yaml3.SequenceNode {
+		return nil
+	}
+
+	return n.Content
+}
+
+// v1beta1ToV1 converts dependsOn from a list of mappings with a name to a list of names.
+// Unresolved variables are not an error in v1beta1, so strictEnvsubst: false is set if it's not set.
+func v1beta1ToV1(root *yaml3.Node, edits *textEdits) error {
+	addField(root, edits, "strictEnvsubst", "false")
+
+	for _, c := range componentNodes(root) {
+		dependsOn := mappingValue(c, "dependsOn")
+		if dependsOn == nil || dependsOn.Kind != yaml3.SequenceNode {
+			continue
+		}
+
+		for i, d := range dependsOn.Content {
+			name := mappingValue(d, "name")
+			if name == nil || len(d.Content) != 2 {
+				// invalid dependencies are reported by Decode
+				continue
+			}
+
+			// the key is removed from the text, and the braces of flow mappings like {name: crds}
+			start := d.Content[0]
+			if d.Style&yaml3.FlowStyle != 0 {
+				start = d
+				if err := edits.removeClosingBrace(name); err != nil {
+					return err
+				}
+			}
+			edits.add(position(start), position(name), "")
+
+			name.HeadComment = joinComments(d.HeadComment, d.Content[0].HeadComment, name.HeadComment)
+			name.LineComment = joinComments(d.LineComment, d.Content[0].LineComment, name.LineComment)
+			dependsOn.Content[i] = name
+		}
+	}
+
+	return nil
+}
+
+// v1ToV1beta1 converts dependsOn from a list of names to a list of mappings with a name.
+// Unresolved variables are an error in v1, so strictEnvsubst: true is set if it's not set.
+func v1ToV1beta1(root *yaml3.Node, edits *textEdits) error {
+	addField(root, edits, "strictEnvsubst", "true")
+
+	for _, c := range componentNodes(root) {
+		dependsOn := mappingValue(c, "dependsOn")
+		if dependsOn == nil || dependsOn.Kind != yaml3.SequenceNode {
+			continue
+		}
+
+		for i, d := range dependsOn.Content {
+			if d.Kind != yaml3.ScalarNode {
+				continue
+			}
+
+			// name: is also valid in flow sequences, like [name: crds]
+			edits.add(position(d), position(d), "name: ")
+
+			key := &yaml3.Node{Kind: yaml3.ScalarNode, Tag: "!!str", Value: "name", Line: d.Line, Column: d.Column}
+			dependsOn.Content[i] = &yaml3.Node{
+				Kind:        yaml3.MappingNode,
+				Tag:         "!!map",
+				Line:        d.Line,
+				Column:      d.Column,
+				HeadComment: d.HeadComment,
+				LineComment: d.LineComment,
+				Content:     []*yaml3.Node{key, {Kind: yaml3.ScalarNode, Tag: d.Tag, Value: d.Value, Style: d.Style, Line: d.Line, Column: d.Column}},
+			}
+		}
+	}
+
+	return nil
+}
+
+// addField adds a field with a boolean value to the playbook after the apiVersion and kind,
+// if the playbook doesn't have it
+func addField(root *yaml3.Node, edits *textEdits, key string, value string) {
+	if mappingValue(root, key) != nil {
+		return
+	}
+
+	index := 0
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if k := root.Content[i].Value; k == "apiVersion" || k == "kind" {
+			index = i + 2
+		}
+	}
+
+	if index == 0 {
+		return
+	}
+
+	after := root.Content[index-2 : index]
+	edits.insertField(after[0], after[1], root.Style&yaml3.FlowStyle != 0, key+": "+value)
+
+	field := []*yaml3.Node{
+		{Kind: yaml3.ScalarNode, Tag: "!!str", Value: key},
+		{Kind: yaml3.ScalarNode, Tag: "!!bool", Value: value},
+	}
+	root.Content = append(root.Content[:index], append(field, root.Content[index:]...)...)
+}
+
+// Warnings returns the warnings of reading the playbook and the included playbooks, like for deprecated versions
+func (pb *Playbook) Warnings() []string {
+	return pb.warnings
+}
+
+// UnmarshalYAML reads the name of the component, dependencies are written as names since v1
+func (d *DependsSpec) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	return unmarshal(&d.Name)
+}
+
+// MarshalYAML writes the name of the component
+func (d DependsSpec) MarshalYAML() (interface{}, error) {
+	return d.Name, nil
+}
+
+// joinComments joins the non-empty comments by a newline
+func joinComments(comments ...string) string {
+	var res []string
+	for _, c := range comments {
+		if c != "" {
+			res = append(res, c)
+		}
+	}
+
+	return strings.Join(res, "\n")
+}
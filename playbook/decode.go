@@ -0,0 +1,474 @@
+package playbook
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gprossliner/kustomizepb/knownerror"
+	"gopkg.in/yaml.v2"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// Position is the location of an element in a playbook file
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// IsValid is false for elements which are not read from a file, like expanded dependencies
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// errorf creates a KnownError prefixed by the position, if it's valid
+func (p Position) errorf(format string, a ...any) error {
+	return p.wrap(knownerror.NewKnownError(format, a...))
+}
+
+// wrap prefixes the message of the error by the position, if it's valid
+func (p Position) wrap(err error) error {
+	if err == nil || !p.IsValid() {
+		return err
+	}
+
+	code := 0
+	if ke, ok := err.(*knownerror.KnownError); ok {
+		code = ke.Code
+	}
+
+	return knownerror.NewKnownError("%s: %s", p, err).WithCode(code)
+}
+
+// Decode reads the playbook strictly. All unknown fields and type errors are reported, with
+// the file, line and column. The file is only used for messages.
+// Playbooks of other versions are converted to ApiVersion, see Convert.
+func Decode(data []byte, file string) (*Playbook, error) {
+	fileLabel := file
+	if fileLabel == "" {
+		fileLabel = "playbook"
+	}
+
+	var doc yaml3.Node
+	if err := yaml3.Unmarshal(data, &doc); err != nil {
+		return nil, knownerror.NewKnownError("%s: Error parsing yaml: %s", fileLabel, err)
+	}
+
+	pb := &Playbook{}
+	if len(doc.Content) == 0 {
+		return pb, nil
+	}
+
+	root := doc.Content[0]
+
+	// the playbook is checked against the schema of its version, unknown versions against the hub
+	schema := playbookSchemas[ApiVersion]
+	if n := apiVersionNode(root); n != nil {
+		if s, ok := playbookSchemas[n.Value]; ok {
+			schema = s
+		}
+	}
+
+	d := &decoder{file: file, definitions: schema.Definitions}
+	d.check(root, schema)
+	if len(d.errs) > 0 {
+		return nil, knownerror.NewKnownError("%s", strings.Join(d.errs, "\n"))
+	}
+
+	// other versions are converted to the version of the types
+	var warnings []string
+	converted := false
+	if n := apiVersionNode(root); n != nil && n.Value != ApiVersion {
+		if c, ok := conversions[n.Value]; ok {
+			if c.deprecated {
+				warnings = append(warnings, fmt.Sprintf("%s: apiVersion %s is deprecated, convert the playbook to %s with the migrate command", fileLabel, n.Value, ApiVersion))
+			}
+
+			if err := convertToHub(root, nil); err != nil {
+				return nil, err
+			}
+			converted = true
+		}
+	}
+
+	// the checked nodes are marshalled, because the check normalizes booleans
+	if converted {
+		var err error
+		data, err = yaml3.Marshal(root)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the structs are decoded with yaml.v2, because the code relies on its types for nested maps
+	if err := yaml.Unmarshal(data, pb); err != nil {
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			return nil, knownerror.NewKnownError("%s: Error parsing yaml: %s", fileLabel, strings.Join(typeErr.Errors, "\n"))
+		}
+
+		return nil, knownerror.NewKnownError("%s: Error parsing yaml: %s", fileLabel, err)
+	}
+
+	pb.setPositions(root, file)
+	pb.warnings = warnings
+	return pb, nil
+}
+
+// yaml11Bools are the booleans of YAML 1.1 which are not booleans in YAML 1.2, by their value
+var yaml11Bools = map[string]string{
+	"y": "true", "Y": "true", "yes": "true", "Yes": "true", "YES": "true", "on": "true", "On": "true", "ON": "true",
+	"n": "false", "N": "false", "no": "false", "No": "false", "NO": "false", "off": "false", "Off": "false", "OFF": "false",
+}
+
+// decoder checks yaml nodes against the schema of the playbook
+type decoder struct {
+	file string
+	errs []string
+
+	// definitions are the definitions of the schema, which are referenced by $ref
+	definitions map[string]*Schema
+}
+
+// resolve returns the definition if s is a reference
+func (d *decoder) resolve(s *Schema) *Schema {
+	if s.Ref == "" {
+		return s
+	}
+
+	return d.definitions[strings.TrimPrefix(s.Ref, "#/definitions/")]
+}
+
+func (d *decoder) errorf(n *yaml3.Node, format string, a ...any) {
+	pos := Position{File: d.file, Line: n.Line, Column: n.Column}
+	d.errs = append(d.errs, pos.String()+": "+fmt.Sprintf(format, a...))
+}
+
+// check reports all fields of n which are unknown, missing or have the wrong type for the schema
+func (d *decoder) check(n *yaml3.Node, s *Schema) {
+	if n.Kind == yaml3.AliasNode {
+		n = n.Alias
+	}
+
+	if n.Kind == yaml3.ScalarNode && n.ShortTag() == "!!null" {
+		return
+	}
+
+	s = d.resolve(s)
+	for _, sub := range s.AllOf {
+		d.check(n, sub)
+	}
+
+	switch s.Type {
+	case "object":
+		if n.Kind != yaml3.MappingNode {
+			if s.Title != "" {
+				d.errorf(n, "Expected a mapping for %s, not %s", s.Title, describeNode(n))
+			} else {
+				d.errorf(n, "Expected a mapping, not %s", describeNode(n))
+			}
+			return
+		}
+
+		d.checkMapping(n, s)
+
+	case "array":
+		if n.Kind != yaml3.SequenceNode {
+			d.errorf(n, "Expected a list, not %s", describeNode(n))
+			return
+		}
+
+		for _, item := range n.Content {
+			d.check(item, s.Items)
+		}
+
+	case "string":
+		// numbers and booleans are read as strings too
+		if n.Kind != yaml3.ScalarNode {
+			d.errorf(n, "Expected a string, not %s", describeNode(n))
+			return
+		}
+
+		if len(s.Enum) > 0 && !contains(s.Enum, n.Value) {
+			d.errorf(n, "Expected one of %s, not '%s'", strings.Join(s.Enum, ", "), n.Value)
+		}
+
+	case "boolean":
+		if n.Kind != yaml3.ScalarNode {
+			d.errorf(n, "Expected a boolean, not %s", describeNode(n))
+			return
+		}
+
+		// the YAML 1.1 spellings like yes and on are read by yaml.v2, they are normalized
+		// so they are also read if the nodes are marshalled again
+		if value, ok := yaml11Bools[n.Value]; ok && n.ShortTag() == "!!str" && n.Style&(yaml3.SingleQuotedStyle|yaml3.DoubleQuotedStyle) == 0 {
+			n.Tag, n.Value = "!!bool", value
+		}
+
+		if n.ShortTag() != "!!bool" {
+			d.errorf(n, "Expected a boolean, not %s", describeNode(n))
+		}
+
+	case "integer":
+		if n.Kind != yaml3.ScalarNode || n.ShortTag() != "!!int" {
+			d.errorf(n, "Expected an integer, not %s", describeNode(n))
+		}
+	}
+}
+
+// checkMapping checks the keys of a mapping against the properties of the schema
+func (d *decoder) checkMapping(n *yaml3.Node, s *Schema) {
+	additional := s.additional()
+	seen := map[string]bool{}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], n.Content[i+1]
+
+		if seen[k.Value] {
+			d.errorf(k, "Field '%s' is defined multiple times in %s", k.Value, s.Title)
+		}
+		seen[k.Value] = true
+
+		if ps, ok := s.Properties[k.Value]; ok {
+			d.check(v, ps)
+			continue
+		}
+
+		if additional == nil {
+			msg := fmt.Sprintf("Unknown field '%s' in %s", k.Value, s.Title)
+			if sug := suggestField(k.Value, s.Properties); sug != "" {
+				msg += fmt.Sprintf(", did you mean '%s'?", sug)
+			}
+			d.errorf(k, "%s", msg)
+			continue
+		}
+
+		d.check(v, additional)
+	}
+
+	var missing []string
+	for _, name := range s.Required {
+		if !seen[name] {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) > 0 {
+		d.errorf(n, "%s needs to have %s", s.Title, strings.Join(missing, ", "))
+	}
+
+	// oneOf is only used for mutually exclusive fields, like the condition types
+	if len(s.OneOf) > 0 {
+		var names, set []string
+		for _, sub := range s.OneOf {
+			names = append(names, sub.Required...)
+			if len(sub.Required) > 0 && seen[sub.Required[0]] {
+				set = append(set, sub.Required[0])
+			}
+		}
+
+		switch len(set) {
+		case 0:
+			d.errorf(n, "%s needs to have one of %s", s.Title, strings.Join(names, ", "))
+		case 1:
+		default:
+			d.errorf(n, "%s must have only one of %s, not %s", s.Title, strings.Join(names, ", "), strings.Join(set, " and "))
+		}
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// describeNode describes the value of a node for error messages
+func describeNode(n *yaml3.Node) string {
+	switch n.Kind {
+	case yaml3.MappingNode:
+		return "a mapping"
+	case yaml3.SequenceNode:
+		return "a list"
+	default:
+		return fmt.Sprintf("'%s'", n.Value)
+	}
+}
+
+// yamlFields returns the fields of the struct by yaml name
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	res := map[string]reflect.StructField{}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+
+		res[name] = f
+	}
+
+	return res
+}
+
+// suggestField returns the known field which is most similar to name, if there is a similar one
+func suggestField(name string, fields map[string]*Schema) string {
+	best, bestDistance := "", 3
+	for f := range fields {
+		dist := levenshtein(strings.ToLower(name), strings.ToLower(f))
+		if dist < bestDistance || (dist == bestDistance && best != "" && f < best) {
+			best, bestDistance = f, dist
+		}
+	}
+
+	return best
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// setPositions records the positions of the elements which are reported by Validate
+func (pb *Playbook) setPositions(root *yaml3.Node, file string) {
+	at := func(n *yaml3.Node) Position {
+		return Position{File: file, Line: n.Line, Column: n.Column}
+	}
+
+	items := func(n *yaml3.Node, key string) []*yaml3.Node {
+		v := mappingValue(n, key)
+		if v == nil || v.Kind != yaml3.SequenceNode {
+			return nil
+		}
+		return v.Content
+	}
+
+	conditions := func(cs ConditionSlice, nodes []*yaml3.Node) {
+		for i := range cs {
+			if i < len(nodes) {
+				cs[i].pos = at(nodes[i])
+			}
+		}
+	}
+
+	pb.positions = map[string]Position{}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		pb.positions[root.Content[i].Value] = at(root.Content[i])
+	}
+
+	conditions(pb.Prerequisites, items(root, "prerequisites"))
+
+	for i, n := range items(root, "components") {
+		if i >= len(pb.Components) {
+			break
+		}
+
+		c := &pb.Components[i]
+		c.pos = at(n)
+		conditions(c.ReadinessConditions, items(n, "readinessConditions"))
+		conditions(c.ApplyConditions, items(n, "applyConditions"))
+
+		for j, o := range items(n, "outputs") {
+			if j < len(c.Outputs) {
+				c.Outputs[j].pos = at(o)
+			}
+		}
+	}
+
+	for i, n := range items(root, "vars") {
+		if i < len(pb.Vars) {
+			pb.Vars[i].pos = at(n)
+		}
+	}
+
+	for i, n := range items(root, "varSources") {
+		if i < len(pb.VarSources) {
+			pb.VarSources[i].pos = at(n)
+		}
+	}
+}
+
+// mappingValue returns the value of the key in a mapping node, or nil
+func mappingValue(n *yaml3.Node, key string) *yaml3.Node {
+	if n.Kind != yaml3.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+// copyPositions copies the positions of a component to a copy, which has been created by yaml
+func (c *Component) copyPositions(to *Component) {
+	to.pos = c.pos
+
+	for i := range c.ReadinessConditions {
+		if i < len(to.ReadinessConditions) {
+			to.ReadinessConditions[i].pos = c.ReadinessConditions[i].pos
+		}
+	}
+
+	for i := range c.ApplyConditions {
+		if i < len(to.ApplyConditions) {
+			to.ApplyConditions[i].pos = c.ApplyConditions[i].pos
+		}
+	}
+
+	for i := range c.Outputs {
+		if i < len(to.Outputs) {
+			to.Outputs[i].pos = c.Outputs[i].pos
+		}
+	}
+}
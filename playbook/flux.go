@@ -0,0 +1,109 @@
+package playbook
+
+import (
+	"context"
+
+	"github.com/gprossliner/kustomizepb/knownerror"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	FluxHelmGroup      = "helm.toolkit.fluxcd.io"
+	FluxKustomizeGroup = "kustomize.toolkit.fluxcd.io"
+)
+
+// fluxStatusCondition is the part of a metav1.Condition we evaluate
+type fluxStatusCondition struct {
+	Status             string
+	Reason             string
+	Message            string
+	ObservedGeneration int64
+}
+
+func (hrc *HelmReleaseReadyCondition) IsFulfilled(ctx context.Context, ec *EvalContext) (bool, error) {
+	return isFluxObjectReady(ctx, ec, FluxHelmGroup, "HelmRelease", hrc.Namespace, hrc.Name)
+}
+
+func (fkc *FluxKustomizationReadyCondition) IsFulfilled(ctx context.Context, ec *EvalContext) (bool, error) {
+	return isFluxObjectReady(ctx, ec, FluxKustomizeGroup, "Kustomization", fkc.Namespace, fkc.Name)
+}
+
+// isFluxObjectReady tests the Ready condition of the flux object.
+// A Stalled object will not get ready without intervention, so an error is returned.
+func isFluxObjectReady(ctx context.Context, ec *EvalContext, group, kind, namespace, name string) (bool, error) {
+	gvr, err := ec.KubeAccess.TryGetPreferredGroupVersionResource(group, kind)
+	if err != nil {
+		return false, err
+	}
+
+	if gvr == nil {
+		return ec.NotFulfilled("Resource %s of group %s not found, is flux installed?", kind, group), nil
+	}
+
+	obj, err := ec.KubeAccess.GetObject(ctx, *gvr, namespace, name)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			return ec.NotFulfilled("%s %s/%s not found", kind, namespace, name), nil
+		}
+		return false, err
+	}
+
+	return fluxReadiness(ec, obj)
+}
+
+// fluxReadiness evaluates the status of a flux object
+func fluxReadiness(ec *EvalContext, obj *unstructured.Unstructured) (bool, error) {
+	kind, namespace, name := obj.GetKind(), obj.GetNamespace(), obj.GetName()
+
+	conditions := fluxStatusConditions(obj)
+
+	// the status, including Stalled, may be left over from the previous generation
+	generation := obj.GetGeneration()
+	observedGeneration, _, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+	if observedGeneration != generation {
+		return ec.NotFulfilled("%s %s/%s generation %d not yet observed", kind, namespace, name, generation), nil
+	}
+
+	if stalled, ok := conditions["Stalled"]; ok && stalled.Status == "True" && (stalled.ObservedGeneration == 0 || stalled.ObservedGeneration == generation) {
+		return false, knownerror.NewKnownError("%s %s/%s is stalled (%s): %s", kind, namespace, name, stalled.Reason, stalled.Message)
+	}
+
+	ready, ok := conditions["Ready"]
+	if !ok {
+		return ec.NotFulfilled("%s %s/%s has no Ready condition", kind, namespace, name), nil
+	}
+
+	if ready.ObservedGeneration != 0 && ready.ObservedGeneration != generation {
+		return ec.NotFulfilled("%s %s/%s Ready condition is outdated", kind, namespace, name), nil
+	}
+
+	if ready.Status != "True" {
+		return ec.NotFulfilled("%s %s/%s is not Ready (%s): %s", kind, namespace, name, ready.Reason, ready.Message), nil
+	}
+
+	return true, nil
+}
+
+// fluxStatusConditions returns the status conditions of obj by type
+func fluxStatusConditions(obj *unstructured.Unstructured) map[string]fluxStatusCondition {
+	res := map[string]fluxStatusCondition{}
+
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		cm, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		t, _, _ := unstructured.NestedString(cm, "type")
+		status, _, _ := unstructured.NestedString(cm, "status")
+		reason, _, _ := unstructured.NestedString(cm, "reason")
+		message, _, _ := unstructured.NestedString(cm, "message")
+		og, _, _ := unstructured.NestedInt64(cm, "observedGeneration")
+
+		res[t] = fluxStatusCondition{status, reason, message, og}
+	}
+
+	return res
+}
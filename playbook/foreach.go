@@ -0,0 +1,195 @@
+package playbook
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/gprossliner/kustomizepb/knownerror"
+	"gopkg.in/yaml.v2"
+)
+
+// InstanceSeparator separates the name of a component with forEach or matrix from the key of the item
+const InstanceSeparator = "-"
+
+// Expand replaces all components with forEach or matrix by their instances.
+// Dependencies on such a component are replaced by dependencies on all instances.
+func (pb *Playbook) Expand() error {
+	var components []Component
+
+	for _, c := range pb.Components {
+		items, err := c.items()
+		if err != nil {
+			return c.pos.wrap(err)
+		}
+
+		if items == nil {
+			components = append(components, c)
+			continue
+		}
+
+		for _, item := range items {
+			instance, err := c.instance(item)
+			if err != nil {
+				return err
+			}
+
+			components = append(components, instance)
+		}
+	}
+
+	// instances of included playbooks are considered too, because they may be referenced by prefixed names
+	instances := map[string][]string{}
+	for _, c := range components {
+		if c.InstanceOf != "" {
+			instances[c.InstanceOf] = append(instances[c.InstanceOf], c.Name)
+		}
+	}
+
+	for i := range components {
+		c := &components[i]
+
+		var dependsOn []DependsSpec
+		for _, d := range c.DependsOn {
+			names, ok := instances[d.Name]
+			if !ok {
+				dependsOn = append(dependsOn, d)
+				continue
+			}
+
+			for _, name := range names {
+				dependsOn = append(dependsOn, DependsSpec{Name: name})
+			}
+		}
+
+		c.DependsOn = dependsOn
+	}
+
+	pb.Components = components
+	return nil
+}
+
+// items returns the forEach items or the combinations of the matrix, or nil if the component is not expanded
+func (c *Component) items() ([]ForEachItem, error) {
+	if len(c.ForEach) > 0 && len(c.Matrix) > 0 {
+		return nil, knownerror.NewKnownError("Component '%s' must not have both forEach and matrix", c.Name)
+	}
+
+	// every instance would define the same outputs
+	if (len(c.ForEach) > 0 || len(c.Matrix) > 0) && len(c.Outputs) > 0 {
+		return nil, knownerror.NewKnownError("Component '%s' must not have outputs, because it has forEach or matrix", c.Name)
+	}
+
+	if len(c.ForEach) > 0 {
+		keys := map[string]bool{}
+		for _, item := range c.ForEach {
+			if item.Key == "" {
+				return nil, knownerror.NewKnownError("All forEach items of component '%s' need to have a key", c.Name)
+			}
+
+			if keys[item.Key] {
+				return nil, knownerror.NewKnownError("Key '%s' is used multiple times in forEach of component '%s'", item.Key, c.Name)
+			}
+			keys[item.Key] = true
+		}
+
+		return c.ForEach, nil
+	}
+
+	if len(c.Matrix) > 0 {
+		return c.matrixItems()
+	}
+
+	return nil, nil
+}
+
+// matrixItems returns all combinations of the matrix values, the variables are combined in alphabetical order
+func (c *Component) matrixItems() ([]ForEachItem, error) {
+	var names []string
+	for name, values := range c.Matrix {
+		if len(values) == 0 {
+			return nil, knownerror.NewKnownError("Matrix variable '%s' of component '%s' has no values", name, c.Name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	items := []ForEachItem{{Vars: map[string]string{}}}
+	for _, name := range names {
+		var next []ForEachItem
+		for _, item := range items {
+			for _, value := range c.Matrix[name] {
+				vars := map[string]string{name: value}
+				for k, v := range item.Vars {
+					vars[k] = v
+				}
+
+				key := value
+				if item.Key != "" {
+					key = item.Key + InstanceSeparator + value
+				}
+
+				next = append(next, ForEachItem{Key: key, Vars: vars})
+			}
+		}
+		items = next
+	}
+
+	return items, nil
+}
+
+// instance creates a deep copy of the component for the item
+func (c *Component) instance(item ForEachItem) (Component, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return Component{}, err
+	}
+
+	instance := Component{}
+	err = yaml.Unmarshal(data, &instance)
+	if err != nil {
+		return Component{}, err
+	}
+
+	c.copyPositions(&instance)
+	if c.Kustomization == nil {
+		instance.Kustomization = nil
+	}
+	instance.Name = c.Name + InstanceSeparator + strings.ToLower(item.Key)
+	instance.InstanceOf = c.Name
+	instance.ItemVars = item.Vars
+	instance.ForEach = nil
+	instance.Matrix = nil
+
+	return instance, nil
+}
+
+// validateInstances checks that the item vars of instances are substituted. This is checked
+// after the profile is applied, which may enable envsubst.
+func (pb *Playbook) validateInstances() []error {
+	var errs []error
+	for _, c := range pb.Components {
+		if len(c.ItemVars) > 0 && !c.Envsubst {
+			errs = append(errs, c.pos.errorf("Component '%s' needs to have envsubst enabled, to use the vars of forEach or matrix", c.Name))
+		}
+	}
+
+	return errs
+}
+
+// Vars returns vars, with the item vars of the component taking precedence
+func (c *Component) Vars(vars map[string]string) map[string]string {
+	if len(c.ItemVars) == 0 {
+		return vars
+	}
+
+	res := map[string]string{}
+	for k, v := range vars {
+		res[k] = v
+	}
+
+	for k, v := range c.ItemVars {
+		res[k] = v
+	}
+
+	return res
+}
@@ -0,0 +1,100 @@
+//go:build ignore
+
+// gen_schema_docs extracts the doc comments of the types of the playbook package and their fields,
+// which are the descriptions of the JSON Schema. It's run by go generate.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"sort"
+	"strings"
+)
+
+const outputFile = "schema_docs.go"
+
+func main() {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go") && fi.Name() != outputFile
+	}, parser.ParseComments)
+	if err != nil {
+		panic(err)
+	}
+
+	docs := map[string]string{}
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				gd, ok := decl.(*ast.GenDecl)
+				if !ok || gd.Tok != token.TYPE {
+					continue
+				}
+
+				for _, spec := range gd.Specs {
+					ts := spec.(*ast.TypeSpec)
+					st, ok := ts.Type.(*ast.StructType)
+					if !ok || !ts.Name.IsExported() {
+						continue
+					}
+
+					doc := ts.Doc
+					if doc == nil && len(gd.Specs) == 1 {
+						doc = gd.Doc
+					}
+					addDoc(docs, ts.Name.Name, doc)
+
+					for _, f := range st.Fields.List {
+						for _, name := range f.Names {
+							if name.IsExported() {
+								addDoc(docs, ts.Name.Name+"."+name.Name, f.Doc)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	var keys []string
+	for k := range docs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by gen_schema_docs.go; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "package playbook\n\n")
+	fmt.Fprintf(&b, "// schemaDocs are the doc comments of the types and fields, used as descriptions of the JSON Schema\n")
+	fmt.Fprintf(&b, "var schemaDocs = map[string]string{\n")
+	for _, k := range keys {
+		fmt.Fprintf(&b, "\t%q: %q,\n", k, docs[k])
+	}
+	fmt.Fprintf(&b, "}\n")
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		panic(err)
+	}
+
+	if err := os.WriteFile(outputFile, src, 0644); err != nil {
+		panic(err)
+	}
+}
+
+// addDoc adds the comment as a single line, if there is one
+func addDoc(docs map[string]string, key string, doc *ast.CommentGroup) {
+	if doc == nil {
+		return
+	}
+
+	text := strings.Join(strings.Fields(doc.Text()), " ")
+	if text != "" {
+		docs[key] = text
+	}
+}
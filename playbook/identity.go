@@ -0,0 +1,91 @@
+package playbook
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gprossliner/kustomizepb/knownerror"
+	"github.com/gprossliner/kustomizepb/kubeaccess"
+)
+
+// GetClusterIdentity reads the identity of the cluster ka is connected to
+func GetClusterIdentity(ctx context.Context, ka *kubeaccess.KubeAccess) (*ClusterIdentity, error) {
+	uid, err := ka.KubeSystemUID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ClusterIdentity{
+		KubeSystemUID: uid,
+		Server:        ka.KubeRest.Host,
+	}, nil
+}
+
+// WithEnvs returns the identity with the values from the envfile applied.
+// The result is nil if neighter the playbook nor the envs pin an identity.
+func (ci *ClusterIdentity) WithEnvs(envs map[string]string) *ClusterIdentity {
+	res := &ClusterIdentity{}
+	if ci != nil {
+		*res = *ci
+	}
+
+	if uid := envs[EnvClusterUID]; uid != "" {
+		res.KubeSystemUID = uid
+	}
+
+	if server := envs[EnvClusterServer]; server != "" {
+		res.Server = server
+	}
+
+	if res.KubeSystemUID == "" && res.Server == "" && res.ConfigMap == nil {
+		return nil
+	}
+
+	return res
+}
+
+// Verify checks if the cluster ka is connected to matches the identity
+func (ci *ClusterIdentity) Verify(ctx context.Context, ka *kubeaccess.KubeAccess) error {
+	var mismatches []string
+
+	if ci.KubeSystemUID != "" {
+		uid, err := ka.KubeSystemUID(ctx)
+		if err != nil {
+			return err
+		}
+
+		if uid != ci.KubeSystemUID {
+			mismatches = append(mismatches, "kube-system UID is '"+uid+"', not '"+ci.KubeSystemUID+"'")
+		}
+	}
+
+	if ci.Server != "" {
+		server := ka.KubeRest.Host
+		if strings.TrimSuffix(server, "/") != strings.TrimSuffix(ci.Server, "/") {
+			mismatches = append(mismatches, "server is '"+server+"', not '"+ci.Server+"'")
+		}
+	}
+
+	if ci.ConfigMap != nil {
+		labels, found, err := ka.GetConfigMapLabels(ctx, ci.ConfigMap.Namespace, ci.ConfigMap.Name)
+		if err != nil {
+			return err
+		}
+
+		if !found {
+			mismatches = append(mismatches, "ConfigMap "+ci.ConfigMap.Namespace+"/"+ci.ConfigMap.Name+" not found")
+		} else {
+			for k, v := range ci.ConfigMap.Labels {
+				if labels[k] != v {
+					mismatches = append(mismatches, "label "+k+" of ConfigMap "+ci.ConfigMap.Namespace+"/"+ci.ConfigMap.Name+" is '"+labels[k]+"', not '"+v+"'")
+				}
+			}
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return knownerror.NewKnownError("The cluster doesn't match the clusterIdentity: %s", strings.Join(mismatches, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,245 @@
+package playbook
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/gprossliner/kustomizepb/knownerror"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// IncludeSeparator separates the name of an include from the name of an included component
+const IncludeSeparator = "/"
+
+// kustomizationPathLists are the kustomization fields with lists of local paths
+var kustomizationPathLists = []string{
+	"resources", "components", "crds", "bases", "configurations",
+	"patchesStrategicMerge", "generators", "transformers", "validators",
+}
+
+// kustomizationPathObjects are the kustomization fields with lists of objects with a path
+var kustomizationPathObjects = []string{"patches", "patchesJson6902", "replacements"}
+
+// kustomizationGenerators are the kustomization fields with generators reading local files
+var kustomizationGenerators = []string{"configMapGenerator", "secretGenerator"}
+
+// Validate checks the name and the path of the include
+func (inc Include) Validate() error {
+	if errs := validation.IsDNS1123Label(inc.Name); len(errs) > 0 {
+		return knownerror.NewKnownError("Invalid include name '%s': %s", inc.Name, strings.Join(errs, "/"))
+	}
+
+	if inc.Path == "" {
+		return knownerror.NewKnownError("Include '%s' has no path", inc.Name)
+	}
+
+	return nil
+}
+
+// Merge appends the components and prerequisites of the included playbook.
+// Components are prefixed with the name of the include, and all local paths are
+// rebased by relDir, the directory of the included playbook relative to this playbook.
+func (pb *Playbook) Merge(inc Include, included *Playbook, relDir string) error {
+	err := inc.Validate()
+	if err != nil {
+		return err
+	}
+
+	for _, c := range included.Components {
+		c.Name = inc.Name + IncludeSeparator + c.Name
+		if c.InstanceOf != "" {
+			c.InstanceOf = inc.Name + IncludeSeparator + c.InstanceOf
+		}
+
+		dependsOn := make([]DependsSpec, len(c.DependsOn))
+		for i, d := range c.DependsOn {
+			dependsOn[i] = DependsSpec{Name: inc.Name + IncludeSeparator + d.Name}
+		}
+		c.DependsOn = dependsOn
+
+		c.Kustomization.rebase(relDir)
+		c.ReadinessConditions.rebase(relDir)
+		c.ApplyConditions.rebase(relDir)
+
+		pb.Components = append(pb.Components, c)
+	}
+
+	included.Prerequisites.rebase(relDir)
+	pb.Prerequisites = append(pb.Prerequisites, included.Prerequisites...)
+
+	// the declarations of the including playbook take precedence
+	declared := map[string]bool{}
+	for _, d := range pb.Vars {
+		declared[d.Name] = true
+	}
+
+	for _, d := range included.Vars {
+		if !declared[d.Name] {
+			pb.Vars = append(pb.Vars, d)
+			declared[d.Name] = true
+		}
+	}
+
+	pb.VarSources = append(pb.VarSources, included.VarSources...)
+
+	// the clusters of the including playbook take precedence
+	for name, cluster := range included.Clusters {
+		if _, ok := pb.Clusters[name]; !ok {
+			if pb.Clusters == nil {
+				pb.Clusters = map[string]Cluster{}
+			}
+			pb.Clusters[name] = cluster
+		}
+	}
+
+	pb.warnings = append(pb.warnings, included.warnings...)
+
+	return nil
+}
+
+// rebase prefixes all local paths in the kustomization with dir
+func (k Kustomization) rebase(dir string) {
+	k.mapLocalPaths(func(p string) string {
+		return filepath.ToSlash(filepath.Join(dir, p))
+	})
+}
+
+// localPaths returns all local paths in the kustomization
+func (k Kustomization) localPaths() []string {
+	var res []string
+	k.mapLocalPaths(func(p string) string {
+		res = append(res, p)
+		return p
+	})
+
+	return res
+}
+
+// LoadsOutsideRoot returns true if a local path of the kustomization is outside of its directory,
+// like the rebased paths of included playbooks. kustomize only loads them without load restrictions.
+func (k Kustomization) LoadsOutsideRoot() bool {
+	for _, p := range k.localPaths() {
+		if p == ".." || strings.HasPrefix(filepath.ToSlash(filepath.Clean(p)), "../") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mapLocalPaths replaces all local paths in the kustomization by the result of f.
+// Absolute paths, URLs and inline patches are not local paths.
+func (k Kustomization) mapLocalPaths(f func(p string) string) {
+	mapPath := func(p interface{}) interface{} {
+		s, ok := p.(string)
+		if !ok || !isLocalPath(s) {
+			return p
+		}
+
+		return f(s)
+	}
+
+	// generator files may be given as key=path
+	mapFile := func(p interface{}) interface{} {
+		s, ok := p.(string)
+		if !ok {
+			return p
+		}
+
+		if key, path, found := strings.Cut(s, "="); found {
+			return key + "=" + mapPath(path).(string)
+		}
+
+		return mapPath(s)
+	}
+
+	mapKey := func(m interface{}, key string) {
+		switch m := m.(type) {
+		case map[interface{}]interface{}:
+			if p, ok := m[key]; ok {
+				m[key] = mapPath(p)
+			}
+		case map[string]interface{}:
+			if p, ok := m[key]; ok {
+				m[key] = mapPath(p)
+			}
+		case Kustomization:
+			if p, ok := m[key]; ok {
+				m[key] = mapPath(p)
+			}
+		}
+	}
+
+	for _, field := range kustomizationPathLists {
+		if paths, ok := k[field].([]interface{}); ok {
+			for i, p := range paths {
+				paths[i] = mapPath(p)
+			}
+		}
+	}
+
+	for _, field := range kustomizationPathObjects {
+		if objs, ok := k[field].([]interface{}); ok {
+			for _, obj := range objs {
+				mapKey(obj, "path")
+			}
+		}
+	}
+
+	for _, field := range kustomizationGenerators {
+		if gens, ok := k[field].([]interface{}); ok {
+			for _, gen := range gens {
+				mapKey(gen, "env")
+				for _, key := range []string{"envs", "files"} {
+					if paths, ok := mapValue(gen, key).([]interface{}); ok {
+						for i, p := range paths {
+							paths[i] = mapFile(p)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// rebase prefixes local commands of exec conditions with dir
+func (cs ConditionSlice) rebase(dir string) {
+	for _, c := range cs {
+		if c.Exec != nil && (strings.HasPrefix(c.Exec.Command, "./") || strings.HasPrefix(c.Exec.Command, "../")) {
+			command := filepath.ToSlash(filepath.Join(dir, c.Exec.Command))
+			if !strings.HasPrefix(command, "../") {
+				command = "./" + command
+			}
+			c.Exec.Command = command
+		}
+	}
+}
+
+// mapValue gets a value of a map, as they are unmarshalled by yaml.
+// Nested maps of a Kustomization have the type Kustomization too.
+func mapValue(m interface{}, key string) interface{} {
+	switch m := m.(type) {
+	case map[interface{}]interface{}:
+		return m[key]
+	case map[string]interface{}:
+		return m[key]
+	case Kustomization:
+		return m[key]
+	}
+
+	return nil
+}
+
+func isLocalPath(p string) bool {
+	if p == "" || filepath.IsAbs(p) || strings.Contains(p, "\n") || strings.Contains(p, "://") {
+		return false
+	}
+
+	for _, prefix := range []string{"github.com/", "gitlab.com/", "bitbucket.org/", "git@", "git::"} {
+		if strings.HasPrefix(p, prefix) {
+			return false
+		}
+	}
+
+	return true
+}
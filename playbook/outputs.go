@@ -0,0 +1,130 @@
+package playbook
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gprossliner/kustomizepb/knownerror"
+)
+
+// OutputProducers returns the name of the producing component by output name
+func (pb *Playbook) OutputProducers() map[string]string {
+	res := map[string]string{}
+	for _, c := range pb.Components {
+		for _, o := range c.Outputs {
+			res[o.Name] = c.Name
+		}
+	}
+
+	return res
+}
+
+// dependsOn returns true if the component depends on name, directly or transitively
+func (pb *Playbook) dependsOn(c *Component, name string, visited map[string]bool) bool {
+	for _, d := range c.DependsOn {
+		if d.Name == name {
+			return true
+		}
+
+		if visited[d.Name] {
+			continue
+		}
+		visited[d.Name] = true
+
+		dc := pb.tryFindComponent(d.Name)
+		if dc != nil && pb.dependsOn(dc, name, visited) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateOutputs checks the output names, and that consumers of outputs depend on the producer
+func (pb *Playbook) validateOutputs() []error {
+	var errs []error
+	producers := map[string]string{}
+
+	for _, c := range pb.Components {
+		for _, o := range c.Outputs {
+			if o.Name == "" {
+				errs = append(errs, o.pos.errorf("An output of component '%s' needs to have a name", c.Name))
+				continue
+			}
+
+			if p, ok := producers[o.Name]; ok {
+				errs = append(errs, o.pos.errorf("Output '%s' of component '%s' is already defined by component '%s'", o.Name, c.Name, p))
+				continue
+			}
+
+			producers[o.Name] = c.Name
+		}
+	}
+
+	if len(producers) == 0 {
+		return errs
+	}
+
+	for i := range pb.Components {
+		c := &pb.Components[i]
+		if !c.Envsubst {
+			continue
+		}
+
+		refs, err := c.envsubstScope().referencedVars()
+		if err != nil {
+			errs = append(errs, c.pos.wrap(err))
+			continue
+		}
+
+		reported := map[string]bool{}
+		for _, ref := range refs {
+			p, isOutput := producers[ref.Name]
+			if !isOutput || reported[ref.Name] {
+				continue
+			}
+
+			if p == c.Name || !pb.dependsOn(c, p, map[string]bool{}) {
+				errs = append(errs, c.pos.errorf("Component '%s' uses output '%s', so it needs to depend on component '%s'", c.Name, ref.Name, p))
+				reported[ref.Name] = true
+			}
+		}
+	}
+
+	// outputs are not available in prerequisites
+	if pb.Envsubst {
+		refs, err := pb.envsubstScopes()[0].referencedVars()
+		if err != nil {
+			errs = append(errs, pb.positions["prerequisites"].wrap(err))
+		}
+
+		for _, ref := range refs {
+			if _, isOutput := producers[ref.Name]; isOutput {
+				errs = append(errs, pb.positions["prerequisites"].errorf("Output '%s' can't be used in prerequisites", ref.Name))
+			}
+		}
+	}
+
+	return errs
+}
+
+// IsSensitive returns true if the output is read from a Secret, so the value must not be written to disk
+func (o Output) IsSensitive() bool {
+	return o.ObjectValue.ApiVersion == "v1" && o.ObjectValue.Kind == "Secret"
+}
+
+// GetOutputs reads the values of all outputs of the component
+func (c *Component) GetOutputs(ctx context.Context, ec *EvalContext) (map[string]string, error) {
+	res := map[string]string{}
+
+	for _, o := range c.Outputs {
+		v, err := o.ObjectValue.GetValue(ctx, ec)
+		if err != nil {
+			return nil, knownerror.NewKnownError("Unable to get output '%s' of component '%s': %s", o.Name, c.Name, err)
+		}
+
+		res[o.Name] = fmt.Sprint(v)
+	}
+
+	return res, nil
+}
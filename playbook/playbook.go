@@ -3,27 +3,25 @@ package playbook
 import (
 	"bytes"
 	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
-	"text/template"
+	"time"
 
 	"github.com/drone/envsubst"
 	"github.com/gprossliner/kustomizepb/knownerror"
 	"github.com/gprossliner/kustomizepb/kubeaccess"
 	"gopkg.in/yaml.v2"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/util/validation"
 )
 
+// Unmarshal reads the playbook strictly, see Decode
 func Unmarshal(data []byte) (*Playbook, error) {
-	playbook := &Playbook{}
-
-	if err := yaml.Unmarshal(data, playbook); err != nil {
-		err, _ := err.(*yaml.TypeError)
-		for _, err := range err.Errors {
-			return nil, knownerror.NewKnownError("Error parsing yaml: %s", err)
-		}
-	}
-
-	return playbook, nil
+	return Decode(data, "")
 }
 
 func (pb *Playbook) tryFindComponent(name string) *Component {
@@ -41,20 +39,29 @@ func (pb *Playbook) Validate() []error {
 	var errs []error
 
 	if pb.ApiVersion != ApiVersion {
-		errs = append(errs, knownerror.NewKnownError("apiVersion must be '%s', not '%s", ApiVersion, pb.ApiVersion))
+		errs = append(errs, pb.positions["apiVersion"].errorf("apiVersion must be '%s', not '%s", ApiVersion, pb.ApiVersion))
 	}
 
 	if pb.Kind != Kind {
-		errs = append(errs, knownerror.NewKnownError("kind must be '%s', not '%s", Kind, pb.Kind))
+		errs = append(errs, pb.positions["kind"].errorf("kind must be '%s', not '%s", Kind, pb.Kind))
 	}
 
+	errs = append(errs, pb.validateConditions()...)
+	errs = append(errs, pb.validateTools()...)
+	errs = append(errs, pb.validateComponentGraph()...)
+	errs = append(errs, pb.validateDeclarations()...)
+	errs = append(errs, pb.validateOutputs()...)
+	errs = append(errs, pb.validateTemplates()...)
+	errs = append(errs, pb.validateClusters()...)
+	errs = append(errs, pb.validateInstances()...)
+
 	// remember visited components for dependency validation
 	var visitedComponents []string
 
 	for _, c := range pb.Components {
 		err := IsValidComponentName(c.Name)
 		if err != nil {
-			errs = append(errs, err)
+			errs = append(errs, c.pos.wrap(err))
 		}
 
 		visitedComponents = append(visitedComponents, c.Name)
@@ -74,17 +81,17 @@ func (pb *Playbook) Validate() []error {
 			// check name
 			err := IsValidComponentName(dp.Name)
 			if err != nil {
-				errs = append(errs, err)
+				errs = append(errs, c.pos.wrap(err))
 			}
 
 			// check dependency exist
 			hasCp := pb.tryFindComponent(dp.Name)
 			if hasCp == nil {
-				errs = append(errs, knownerror.NewKnownError("Dependency '%s' of component '%s' is not defined ", dp.Name, c.Name))
+				errs = append(errs, c.pos.errorf("Dependency '%s' of component '%s' is not defined ", dp.Name, c.Name))
 			} else {
 				// check dependency order
 				if !hasVisited(dp.Name) {
-					errs = append(errs, knownerror.NewKnownError("Dependency '%s' of component '%s' must not be before the component", dp.Name, c.Name))
+					errs = append(errs, c.pos.errorf("Dependency '%s' of component '%s' must not be before the component", dp.Name, c.Name))
 				}
 			}
 
@@ -95,25 +102,41 @@ func (pb *Playbook) Validate() []error {
 
 }
 
+// EnvSubst performs envsubst on the prerequisites and all components at once.
+// Components using outputs of other components need to be substituted with Component.EnvSubst
+// after the outputs are available.
 func (pb *Playbook) EnvSubst(vars map[string]string) error {
-	for i := range pb.Components {
-		c := &pb.Components[i]
-		if c.Envsubst {
-			repl, err := c.Kustomization.EnvSubst(vars)
-			if err != nil {
-				return err
-			}
+	err := pb.CheckUnresolvedVars(vars)
+	if err != nil {
+		return err
+	}
 
-			c.Kustomization = repl
+	for _, s := range pb.envsubstScopes() {
+		err := s.envSubst(vars)
+		if err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-func (cs *ConditionSlice) IsFulfilled(ctx context.Context, ka *kubeaccess.KubeAccess) (bool, error) {
+// NotFulfilled records the reason why a condition is not fulfilled and returns false
+func (ec *EvalContext) NotFulfilled(format string, a ...any) bool {
+	ec.Reason = fmt.Sprintf(format, a...)
+	return false
+}
+
+// Compared records the values a condition compares, so they can be reported
+func (ec *EvalContext) Compared(actual any, expected any) {
+	ec.Actual = fmt.Sprint(actual)
+	ec.Expected = fmt.Sprint(expected)
+}
+
+func (cs *ConditionSlice) IsFulfilled(ctx context.Context, ec *EvalContext) (bool, error) {
+	ec.Reason = ""
 	for _, c := range *cs {
-		ff, err := c.IsFulfilled(ctx, ka)
+		ff, err := c.IsFulfilled(ctx, ec)
 		if err != nil {
 			return false, err
 		}
@@ -126,71 +149,344 @@ func (cs *ConditionSlice) IsFulfilled(ctx context.Context, ka *kubeaccess.KubeAc
 	return true, nil
 }
 
-func (c *Conditions) IsFulfilled(ctx context.Context, ka *kubeaccess.KubeAccess) (bool, error) {
-	if c.CustomResourceDefinition != nil {
-		return c.CustomResourceDefinition.IsFulfilled(ctx, ka)
+func (c *Conditions) IsFulfilled(ctx context.Context, ec *EvalContext) (bool, error) {
+	cond := c.condition()
+	if cond == nil {
+		// this is prevented by Validate
+		return false, knownerror.NewKnownError("A condition needs to have one of %s", strings.Join(conditionTypes, ", "))
+	}
+
+	ec.Reason, ec.Actual, ec.Expected = "", "", ""
+	cec, err := ec.ForCluster(ctx, c.Cluster)
+	if err != nil {
+		return false, err
+	}
+	cec.Actual, cec.Expected = "", ""
+
+	ff, err := cond.IsFulfilled(ctx, cec)
+	if err != nil {
+		return false, err
+	}
+
+	ec.Reason, ec.Actual, ec.Expected = cec.Reason, cec.Actual, cec.Expected
+
+	if !ff && c.Message != "" {
+		if ec.Reason != "" {
+			ec.NotFulfilled("%s: %s", c.Message, ec.Reason)
+		} else {
+			ec.NotFulfilled(c.Message)
+		}
 	}
 
-	if c.Compare != nil {
-		return c.Compare.IsFulfilled(ctx, ka)
+	if ec.Evaluated != nil {
+		ec.Evaluated(ConditionResult{
+			Type:      c.types()[0],
+			Cluster:   c.Cluster,
+			Fulfilled: ff,
+			Reason:    ec.Reason,
+			Actual:    ec.Actual,
+			Expected:  ec.Expected,
+		})
 	}
 
-	if c.ServiceReady != nil {
-		return c.ServiceReady.IsFulfilled(ctx, ka)
+	return ff, nil
+}
+
+// condition returns the condition type which is set, or nil
+func (c *Conditions) condition() Condition {
+	switch {
+	case c.CustomResourceDefinition != nil:
+		return c.CustomResourceDefinition
+	case c.Compare != nil:
+		return c.Compare
+	case c.ServiceReady != nil:
+		return c.ServiceReady
+	case c.Exec != nil:
+		return c.Exec
+	case c.ServerVersion != nil:
+		return c.ServerVersion
+	case c.Nodes != nil:
+		return c.Nodes
+	case c.DefaultStorageClass != nil:
+		return c.DefaultStorageClass
+	case c.HelmReleaseReady != nil:
+		return c.HelmReleaseReady
+	case c.FluxKustomizationReady != nil:
+		return c.FluxKustomizationReady
+	case c.ObjectAbsent != nil:
+		return c.ObjectAbsent
 	}
 
-	// TODO: put this in Validate Playbook too!
-	return false, knownerror.NewKnownError("A condition needs to have customResourceDefinition or compare!")
+	return nil
 }
 
-func (src *ServiceReadyCondition) IsFulfilled(ctx context.Context, ka *kubeaccess.KubeAccess) (bool, error) {
-	return ka.IsServiceReady(ctx, src.Name, src.Namespace)
+func (src *ServiceReadyCondition) IsFulfilled(ctx context.Context, ec *EvalContext) (bool, error) {
+	ready, err := ec.KubeAccess.IsServiceReady(ctx, src.Name, src.Namespace)
+	if err != nil {
+		return false, err
+	}
+
+	if !ready {
+		return ec.NotFulfilled("Service %s/%s has no ready endpoints", src.Namespace, src.Name), nil
+	}
+
+	return true, nil
 }
 
+func (svc *ServerVersionCondition) IsFulfilled(ctx context.Context, ec *EvalContext) (bool, error) {
+	serverVersion, err := ec.KubeAccess.ServerVersion()
+	if err != nil {
+		return false, err
+	}
+
+	ok, err := svc.Constraint.Check(serverVersion)
+	if err != nil {
+		return false, err
+	}
+
+	ec.Compared(serverVersion, svc.Constraint)
+
+	if !ok {
+		return ec.NotFulfilled("Server version %s doesn't satisfy '%s'", serverVersion, svc.Constraint), nil
+	}
+
+	return true, nil
+}
+
+func (nc *NodesCondition) IsFulfilled(ctx context.Context, ec *EvalContext) (bool, error) {
+	nodes, err := ec.KubeAccess.ListNodes(ctx, nc.Selector)
+	if err != nil {
+		return false, err
+	}
+
+	minReady := nc.MinReady
+	if minReady == 0 {
+		minReady = 1
+	}
+
+	ready := 0
+	for _, n := range nodes {
+		if nc.Name != "" && n.Name != nc.Name {
+			continue
+		}
+
+		if kubeaccess.IsNodeReady(&n) {
+			ready++
+		}
+	}
+
+	ec.Compared(ready, fmt.Sprintf(">= %d", minReady))
+	if ready < minReady {
+		if nc.Name != "" {
+			return ec.NotFulfilled("Node %s not found or not Ready", nc.Name), nil
+		}
+		return ec.NotFulfilled("%d Ready nodes found matching '%s', %d required", ready, nc.Selector, minReady), nil
+	}
+
+	return true, nil
+}
+
+func (dsc *DefaultStorageClassCondition) IsFulfilled(ctx context.Context, ec *EvalContext) (bool, error) {
+	names, err := ec.KubeAccess.GetDefaultStorageClasses(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	if len(names) == 0 {
+		return ec.NotFulfilled("No default StorageClass found"), nil
+	}
+
+	if dsc.Name != "" {
+		for _, n := range names {
+			if n == dsc.Name {
+				return true, nil
+			}
+		}
+
+		return ec.NotFulfilled("Default StorageClass is %s, not %s", strings.Join(names, ", "), dsc.Name), nil
+	}
+
+	return true, nil
+}
+
+func (oac *ObjectAbsentCondition) IsFulfilled(ctx context.Context, ec *EvalContext) (bool, error) {
+	gvr, err := ec.KubeAccess.TryGetGroupVersionResource(oac.ApiVersion, oac.Kind)
+	if err != nil {
+		return false, err
+	}
+
+	// if the resource doesn't exist, there can't be any object
+	if gvr == nil {
+		return true, nil
+	}
+
+	var objs []unstructured.Unstructured
+	if oac.Name != "" {
+		obj, err := ec.KubeAccess.GetObject(ctx, *gvr, oac.Namespace, oac.Name)
+		if err != nil {
+			if kerrors.IsNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		}
+
+		objs = append(objs, *obj)
+	} else if oac.Selector != "" {
+		objs, err = ec.KubeAccess.ListObjects(ctx, *gvr, oac.Namespace, oac.Selector)
+		if err != nil {
+			return false, err
+		}
+	} else {
+		return false, knownerror.NewKnownError("objectAbsent needs eighter name or selector")
+	}
+
+	return objectsAbsent(ec, oac.Kind, objs), nil
+}
+
+// objectsAbsent returns true if objs is empty, objects with a deletionTimestamp are still present
+func objectsAbsent(ec *EvalContext, kind string, objs []unstructured.Unstructured) bool {
+	if len(objs) == 0 {
+		return true
+	}
+
+	obj := objs[0]
+	if obj.GetDeletionTimestamp() != nil {
+		return ec.NotFulfilled("%s %s is being deleted", kind, objectRef(obj.GetNamespace(), obj.GetName()))
+	}
+
+	return ec.NotFulfilled("%s %s exists", kind, objectRef(obj.GetNamespace(), obj.GetName()))
+}
+
+func objectRef(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+
+	return namespace + "/" + name
+}
+
+// IsFulfilled runs the command, and returns true if it exits with code 0.
+// The output of the command is recorded as the reason if it fails.
+func (e *ExecCondition) IsFulfilled(ctx context.Context, ec *EvalContext) (bool, error) {
+	timeout := DefaultExecTimeout
+	if e.Timeout != "" {
+		d, err := time.ParseDuration(e.Timeout)
+		if err != nil {
+			return false, knownerror.NewKnownError("Invalid timeout '%s' for exec condition: %s", e.Timeout, err)
+		}
+		timeout = d
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// the directory is absolute, as relative commands are resolved by it and it's the working directory
+	directory, err := filepath.Abs(ec.Directory)
+	if err != nil {
+		return false, err
+	}
+
+	command := e.Command
+	if strings.HasPrefix(command, "./") || strings.HasPrefix(command, "../") {
+		command = filepath.Join(directory, command)
+	}
+
+	cmd := exec.CommandContext(ctx, command, e.Args...)
+	cmd.Dir = directory
+	cmd.Env = os.Environ()
+	for k, v := range ec.Envs {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Env = append(cmd.Env, "KUBECONFIG="+ec.KubeConfig, "KUBECONTEXT="+ec.KubeContext)
+	for k, v := range e.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+
+	var outbuff bytes.Buffer
+	cmd.Stdout = &outbuff
+	cmd.Stderr = &outbuff
+
+	err = cmd.Run()
+	output := strings.TrimSpace(outbuff.String())
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return ec.NotFulfilled("Command '%s' timed out after %s: %s", e.Command, timeout, output), nil
+	}
+
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return ec.NotFulfilled("Command '%s' exited with code %d: %s", e.Command, exitErr.ExitCode(), output), nil
+	}
+
+	if err != nil {
+		return false, knownerror.NewKnownError("Unable to execute command '%s': %s", e.Command, err)
+	}
+
+	return true, nil
+}
+
+// IsValidComponentName checks the name of a component, names of included components are prefixed by the include names
 func IsValidComponentName(name string) error {
-	errs := validation.IsDNS1123Subdomain(name)
-	if len(errs) > 0 {
-		return knownerror.NewKnownError("Invalid component name '%s': %s", name, strings.Join(errs, "/"))
+	for _, part := range strings.Split(name, IncludeSeparator) {
+		errs := validation.IsDNS1123Subdomain(part)
+		if len(errs) > 0 {
+			return knownerror.NewKnownError("Invalid component name '%s': %s", name, strings.Join(errs, "/"))
+		}
 	}
 
 	return nil
 }
 
-func (c CustomResourceDefinitionCondition) IsFulfilled(ctx context.Context, ka *kubeaccess.KubeAccess) (bool, error) {
-	hasCRD, err := ka.HasCustomResourceName(ctx, c.Name)
-	return hasCRD, err
+func (c CustomResourceDefinitionCondition) IsFulfilled(ctx context.Context, ec *EvalContext) (bool, error) {
+	hasCRD, err := ec.KubeAccess.HasCustomResourceName(ctx, c.Name)
+	if err != nil {
+		return false, err
+	}
+
+	if !hasCRD {
+		return ec.NotFulfilled("CustomResourceDefinition %s not found", c.Name), nil
+	}
+
+	return true, nil
 }
 
-func (c CompareCondition) IsFulfilled(ctx context.Context, ka *kubeaccess.KubeAccess) (bool, error) {
-	opValue, err := c.Value.GetValue(ctx, ka)
+func (c CompareCondition) IsFulfilled(ctx context.Context, ec *EvalContext) (bool, error) {
+	opValue, err := c.Value.GetValue(ctx, ec)
 	if err != nil {
 		return false, err
 	}
 
-	opWith, err := c.With.GetValue(ctx, ka)
+	opWith, err := c.With.GetValue(ctx, ec)
 	if err != nil {
 		return false, err
 	}
 
-	isEqual := opValue.(string) == opWith.(string)
-	return isEqual, nil
+	// scalar values may not be strings, especially after envsubst
+	ec.Compared(opValue, opWith)
+	isEqual := fmt.Sprint(opValue) == fmt.Sprint(opWith)
+	if !isEqual {
+		return ec.NotFulfilled("'%v' is not equal to '%v'", opValue, opWith), nil
+	}
+
+	return true, nil
 }
 
-func (op CompareOperant) GetValue(ctx context.Context, ka *kubeaccess.KubeAccess) (interface{}, error) {
+func (op CompareOperant) GetValue(ctx context.Context, ec *EvalContext) (interface{}, error) {
 
 	if op.ScalarValue != nil {
 		return op.ScalarValue, nil
 	}
 
 	if op.ObjectValue != nil {
-		return op.ObjectValue.GetValue(ctx, ka)
+		return op.ObjectValue.GetValue(ctx, ec)
 	}
 
 	// TODO: include this in validation, so we should not get there
 	return "", knownerror.NewKnownError("Neigher scalarValue nor objectValue defined")
 }
 
-func (ov ObjectValueOperant) GetValue(ctx context.Context, ka *kubeaccess.KubeAccess) (interface{}, error) {
+func (ov ObjectValueOperant) GetValue(ctx context.Context, ec *EvalContext) (interface{}, error) {
+
+	ka := ec.KubeAccess
 
 	gvr, err := ka.TryGetGroupVersionResource(ov.ApiVersion, ov.Kind)
 	if err != nil {
@@ -206,7 +502,7 @@ func (ov ObjectValueOperant) GetValue(ctx context.Context, ka *kubeaccess.KubeAc
 		return nil, err
 	}
 
-	res, err := ov.GoTemplate.Evaluate(obj.Object)
+	res, err := ov.GoTemplate.Evaluate(obj.Object, ec.Envs)
 	if err != nil {
 		return nil, err
 	}
@@ -215,22 +511,6 @@ func (ov ObjectValueOperant) GetValue(ctx context.Context, ka *kubeaccess.KubeAc
 
 }
 
-func (gts GoTemplateSpec) Evaluate(obj interface{}) (string, error) {
-	templates, err := template.New("template").Parse(string(gts))
-	if err != nil {
-		return "", err
-	}
-
-	var b bytes.Buffer
-	err = templates.Execute(&b, obj)
-	if err != nil {
-		return "", err
-	}
-
-	res := b.String()
-	return res, nil
-}
-
 // EnvSubst performs envsubst like substitution on all string
 // values in the k
 func (k Kustomization) EnvSubst(vars map[string]string) (Kustomization, error) {
@@ -1,11 +1,17 @@
 package playbook
 
 import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/gprossliner/kustomizepb/knownerror"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/yaml.v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
@@ -107,7 +113,7 @@ func TestEvalGoTemplateSpec(t *testing.T) {
 	obj := UnmarshalKustomization("namespace: ns1\nresources:\n- r1.yaml")
 
 	gts := GoTemplateSpec("{{.namespace}} {{range .resources}}{{.}}{{end}}")
-	res, err := gts.Evaluate(obj)
+	res, err := gts.Evaluate(obj, nil)
 	assert.NoError(t, err)
 
 	assert.Equal(t, "ns1 r1.yaml", res)
@@ -119,12 +125,814 @@ func TestEvalGoTemplateUnstructured(t *testing.T) {
 	obj.SetNamespace("ns")
 
 	gts := GoTemplateSpec("{{.metadata.namespace}} {{.metadata.name}}")
-	res, err := gts.Evaluate(obj.Object)
+	res, err := gts.Evaluate(obj.Object, nil)
 	assert.NoError(t, err)
 
 	assert.Equal(t, "ns name", res)
 }
 
+func TestGoTemplateFunctions(t *testing.T) {
+	obj := map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "Name"},
+		"data":     map[string]interface{}{"password": "c2VjcmV0"},
+		"status": map[string]interface{}{
+			"readyReplicas": int64(3),
+			"version":       "1.26.3",
+			"conditions":    []interface{}{"a", "b"},
+		},
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"{{len .status.conditions}}", "2"},
+		{"{{.status.missing | default \"none\"}}", "none"},
+		{"{{.metadata.name | default \"none\" | lower}}", "name"},
+		{"{{b64dec .data.password}}", "secret"},
+		{"{{toJson .status.conditions}}", `["a","b"]`},
+		{"{{gt (int .status.readyReplicas) 2}}", "true"},
+		{"{{semverCompare \">= 1.24, < 1.27\" .status.version}}", "true"},
+		{"{{dig \"status\" \"loadBalancer\" \"none\" .}}", "none"},
+		{"{{dig \"metadata\" \"name\" \"none\" .}}", "Name"},
+		{"{{hasKey .status \"version\"}}", "true"},
+		{"{{var \"DOMAIN\"}}", "example.com"},
+	}
+
+	for _, tt := range tests {
+		res, err := GoTemplateSpec(tt.template).Evaluate(obj, map[string]string{"DOMAIN": "example.com"})
+		assert.NoError(t, err, tt.template)
+		assert.Equal(t, tt.expected, res, tt.template)
+	}
+}
+
+func TestValidateGoTemplates(t *testing.T) {
+	pb := Playbook{
+		ApiVersion: ApiVersion,
+		Kind:       Kind,
+		Components: []Component{
+			{
+				Name: "c1",
+				ReadinessConditions: ConditionSlice{
+					{Compare: &CompareCondition{
+						Value: CompareOperant{ObjectValue: &ObjectValueOperant{ApiVersion: "v1", Kind: "Pod", Name: "p", GoTemplate: "{{.status.phase"}},
+						With:  CompareOperant{ScalarValue: "Running"},
+					}},
+				},
+				Outputs: []Output{{Name: "IP", ObjectValue: ObjectValueOperant{GoTemplate: "{{unknownFunc .}}"}}},
+			},
+		},
+	}
+
+	errs := pb.Validate()
+	assert.Len(t, errs, 2)
+	assert.Contains(t, errs[0].Error(), "{{.status.phase")
+	assert.Contains(t, errs[1].Error(), "unknownFunc")
+}
+
+func TestMergeInclude(t *testing.T) {
+	included, err := Unmarshal([]byte(`
+prerequisites:
+- exec:
+    command: ./check.sh
+vars:
+- name: DOMAIN
+  default: included.example.com
+- name: ISSUER
+components:
+- name: cert-manager
+  kustomization:
+    resources:
+    - cert-manager
+    - https://github.com/org/repo/manifests
+    patches:
+    - path: patch.yaml
+    secretGenerator:
+    - name: s
+      files:
+      - key=secret.txt
+      envs:
+      - secret.env
+- name: issuer
+  dependsOn:
+  - cert-manager
+`))
+	assert.NoError(t, err)
+
+	pb, err := Unmarshal([]byte(`
+vars:
+- name: DOMAIN
+  default: example.com
+`))
+	assert.NoError(t, err)
+
+	err = pb.Merge(Include{Name: "platform", Path: "../platform"}, included, "../platform")
+	assert.NoError(t, err)
+
+	assert.Equal(t, "platform/cert-manager", pb.Components[0].Name)
+	assert.Equal(t, "platform/issuer", pb.Components[1].Name)
+	assert.Equal(t, "platform/cert-manager", pb.Components[1].DependsOn[0].Name)
+
+	k := pb.Components[0].Kustomization
+	assert.Equal(t, []interface{}{"../platform/cert-manager", "https://github.com/org/repo/manifests"}, k["resources"])
+	assert.Equal(t, "../platform/patch.yaml", k["patches"].([]interface{})[0].(map[interface{}]interface{})["path"])
+
+	gen := k["secretGenerator"].([]interface{})[0].(map[interface{}]interface{})
+	assert.Equal(t, []interface{}{"key=../platform/secret.txt"}, gen["files"])
+	assert.Equal(t, []interface{}{"../platform/secret.env"}, gen["envs"])
+
+	assert.Equal(t, "../platform/check.sh", pb.Prerequisites[0].Exec.Command)
+
+	assert.Len(t, pb.Vars, 2)
+	assert.Equal(t, "example.com", *pb.Vars[0].Default)
+	assert.Equal(t, "ISSUER", pb.Vars[1].Name)
+
+	err = pb.Merge(Include{Name: "Invalid/Name", Path: "x"}, &Playbook{}, "x")
+	assert.Error(t, err)
+}
+
+func TestExpandForEach(t *testing.T) {
+	y := `
+apiVersion: kustomizeplaybook.world-direct.at/v1beta1
+kind: KustomizationPlaybook
+components:
+- name: tenant
+  forEach:
+  - key: team-a
+    vars:
+      NAMESPACE: ns-a
+  - key: team-b
+    vars:
+      NAMESPACE: ns-b
+  envsubst: true
+  kustomization:
+    namespace: ${NAMESPACE}
+- name: monitoring
+  envsubst: true
+  matrix:
+    ENV: [dev, prod]
+    REGION: [eu]
+  dependsOn:
+  - name: tenant-team-a
+- name: ingress
+  dependsOn:
+  - name: tenant
+`
+
+	pb, err := Unmarshal([]byte(y))
+	assert.NoError(t, err)
+
+	err = pb.Expand()
+	assert.NoError(t, err)
+	assert.Empty(t, pb.Validate())
+
+	var names []string
+	for _, c := range pb.Components {
+		names = append(names, c.Name)
+	}
+	assert.Equal(t, []string{"tenant-team-a", "tenant-team-b", "monitoring-dev-eu", "monitoring-prod-eu", "ingress"}, names)
+
+	assert.Equal(t, "tenant", pb.Components[0].InstanceOf)
+	assert.Equal(t, map[string]string{"ENV": "prod", "REGION": "eu"}, pb.Components[3].ItemVars)
+	assert.Equal(t, []DependsSpec{{Name: "tenant-team-a"}}, pb.Components[2].DependsOn)
+	assert.Equal(t, []DependsSpec{{Name: "tenant-team-a"}, {Name: "tenant-team-b"}}, pb.Components[4].DependsOn)
+
+	// the item vars are resolved, and take precedence
+	err = pb.CheckUnresolvedVars(map[string]string{})
+	assert.NoError(t, err)
+
+	c := pb.Components[1]
+	err = c.EnvSubst(map[string]string{"NAMESPACE": "other"}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "ns-b", c.Kustomization["namespace"])
+	assert.Equal(t, "${NAMESPACE}", pb.Components[0].Kustomization["namespace"])
+
+	refs, err := pb.ReferencedVars()
+	assert.NoError(t, err)
+	assert.Empty(t, refs)
+}
+
+func TestExpandErrors(t *testing.T) {
+	pb := Playbook{Components: []Component{{Name: "c", ForEach: []ForEachItem{{Key: "a"}}, Matrix: map[string][]string{"A": {"1"}}}}}
+	assert.ErrorContains(t, pb.Expand(), "both forEach and matrix")
+
+	pb = Playbook{Components: []Component{{Name: "c", ForEach: []ForEachItem{{Key: "a"}, {Key: "a"}}}}}
+	assert.ErrorContains(t, pb.Expand(), "multiple times")
+
+	pb = Playbook{Components: []Component{{Name: "c", Matrix: map[string][]string{"A": {}}}}}
+	assert.ErrorContains(t, pb.Expand(), "has no values")
+
+	pb = Playbook{Components: []Component{{Name: "c", ForEach: []ForEachItem{{Key: "a"}, {Key: "b"}}, Outputs: []Output{{Name: "URL"}}}}}
+	assert.EqualError(t, pb.Expand(), "Component 'c' must not have outputs, because it has forEach or matrix")
+
+	// envsubst is not enabled implicitly
+	pb = Playbook{ApiVersion: ApiVersion, Kind: Kind, Components: []Component{{Name: "c", Matrix: map[string][]string{"A": {"1"}}}}}
+	assert.NoError(t, pb.Expand())
+	assert.False(t, pb.Components[0].Envsubst)
+	errs := pb.Validate()
+	if assert.Len(t, errs, 1) {
+		assert.EqualError(t, errs[0], "Component 'c-1' needs to have envsubst enabled, to use the vars of forEach or matrix")
+	}
+}
+
+func TestConditionCluster(t *testing.T) {
+	ec := &EvalContext{
+		Directory:   t.TempDir(),
+		KubeConfig:  "/kubeconfig",
+		KubeContext: "target",
+		ClusterAccess: func(ctx context.Context, name string) (*ClusterAccess, error) {
+			return &ClusterAccess{KubeConfig: "/kubeconfig", KubeContext: "ctx-" + name}, nil
+		},
+	}
+
+	c := &Conditions{
+		Cluster: "mgmt",
+		Exec:    &ExecCondition{Command: "sh", Args: []string{"-c", `echo $KUBECONTEXT; test "$KUBECONTEXT" = ctx-mgmt`}},
+	}
+
+	isff, err := c.IsFulfilled(context.Background(), ec)
+	assert.NoError(t, err)
+	assert.True(t, isff)
+	assert.Equal(t, "target", ec.KubeContext)
+
+	c.Cluster = ""
+	isff, err = c.IsFulfilled(context.Background(), ec)
+	assert.NoError(t, err)
+	assert.False(t, isff)
+	assert.Contains(t, ec.Reason, "target")
+
+	_, err = (&EvalContext{}).ForCluster(context.Background(), "mgmt")
+	assert.Error(t, err)
+}
+
+func TestValidateClusters(t *testing.T) {
+	pb := Playbook{
+		ApiVersion: ApiVersion,
+		Kind:       Kind,
+		Clusters:   map[string]Cluster{"mgmt": {Context: "kind-mgmt"}},
+		Prerequisites: ConditionSlice{
+			{Cluster: "mgmt", ServerVersion: &ServerVersionCondition{Constraint: ">= 1.24"}},
+		},
+		Components: []Component{
+			{Name: "capi", Cluster: "mgmt"},
+			{Name: "cni", Cluster: "workload", DependsOn: []DependsSpec{{Name: "capi"}}},
+		},
+	}
+
+	errs := pb.Validate()
+	assert.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "Cluster 'workload' of component 'cni' is not defined")
+}
+
+func TestMergePatch(t *testing.T) {
+	// nested maps may have any of the types, like after includes are resolved
+	for _, nested := range []interface{}{
+		map[interface{}]interface{}{"env": "dev", "debug": true},
+		map[string]interface{}{"env": "dev", "debug": true},
+		Kustomization{"env": "dev", "debug": true},
+	} {
+		k := Kustomization{"namespace": "app", "commonLabels": nested}
+		patch := Kustomization{"commonLabels": map[string]interface{}{"env": "prod", "debug": nil}}
+
+		res := mergePatch(k, patch)
+		assert.Equal(t, Kustomization{
+			"namespace":    "app",
+			"commonLabels": map[interface{}]interface{}{"env": "prod"},
+		}, res, "%T", nested)
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	y := `
+apiVersion: kustomizeplaybook.world-direct.at/v1beta1
+kind: KustomizationPlaybook
+vars:
+- name: REPLICAS
+  type: int
+  default: "1"
+components:
+- name: cert-manager
+- name: clusterissuer
+  dependsOn:
+  - name: cert-manager
+- name: app
+  dependsOn:
+  - name: clusterissuer
+  kustomization:
+    namespace: app
+    replicas:
+    - name: app
+      count: 1
+    labels:
+      a: a
+      b: b
+  readinessConditions:
+  - customResourceDefinition:
+      name: a.example.com
+- name: tenant
+  forEach:
+  - key: a
+  - key: b
+profiles:
+  dev:
+    vars:
+      REPLICAS: "2"
+      DOMAIN: dev.example.com
+    components:
+      clusterissuer:
+        disabled: true
+      app:
+        addReadinessConditions:
+        - customResourceDefinition:
+            name: b.example.com
+        kustomization:
+          namespace: null
+          replicas:
+          - name: app
+            count: 3
+          labels:
+            b: null
+            c: c
+      tenant:
+        readinessConditions:
+        - customResourceDefinition:
+            name: t.example.com
+`
+
+	pb, err := Unmarshal([]byte(y))
+	assert.NoError(t, err)
+	assert.NoError(t, pb.Expand())
+
+	err = pb.ApplyProfile("dev")
+	assert.NoError(t, err)
+	assert.Empty(t, pb.Validate())
+
+	assert.Equal(t, "2", *pb.Vars[0].Default)
+	assert.Equal(t, "DOMAIN", pb.Vars[1].Name)
+	assert.Equal(t, "dev.example.com", *pb.Vars[1].Default)
+
+	var names []string
+	for _, c := range pb.Components {
+		names = append(names, c.Name)
+	}
+	assert.Equal(t, []string{"cert-manager", "app", "tenant-a", "tenant-b"}, names)
+
+	app := pb.Components[1]
+	assert.Empty(t, app.DependsOn)
+	assert.Len(t, app.ReadinessConditions, 2)
+	assert.Equal(t, "b.example.com", app.ReadinessConditions[1].CustomResourceDefinition.Name)
+	assert.NotContains(t, app.Kustomization, "namespace")
+	assert.Equal(t, 3, app.Kustomization["replicas"].([]interface{})[0].(map[interface{}]interface{})["count"])
+	assert.Equal(t, map[interface{}]interface{}{"a": "a", "c": "c"}, app.Kustomization["labels"])
+
+	assert.Equal(t, "t.example.com", pb.Components[3].ReadinessConditions[0].CustomResourceDefinition.Name)
+
+	assert.ErrorContains(t, pb.ApplyProfile("prod"), "available profiles: dev")
+
+	pb.Profiles["broken"] = Profile{Components: map[string]ProfileComponent{"unknown": {Disabled: true}}}
+	assert.ErrorContains(t, pb.ApplyProfile("broken"), "Component 'unknown'")
+}
+
+func TestDecodeStrict(t *testing.T) {
+	y := `apiVersion: kustomizeplaybook.world-direct.at/v1beta1
+kind: KustomizationPlaybook
+components:
+- name: c1
+  readinesConditions:
+  - customResourceDefinition:
+      name: a.example.com
+  envsubst: "yes please"
+  dependsOn:
+    name: c0
+- name: c2
+  name: c3
+`
+
+	_, err := Decode([]byte(y), "kustomizationplaybook.yaml")
+	assert.Error(t, err)
+
+	lines := strings.Split(err.Error(), "\n")
+	assert.Equal(t, []string{
+		"kustomizationplaybook.yaml:5:3: Unknown field 'readinesConditions' in Component, did you mean 'readinessConditions'?",
+		"kustomizationplaybook.yaml:8:13: Expected a boolean, not 'yes please'",
+		"kustomizationplaybook.yaml:10:5: Expected a list, not a mapping",
+		"kustomizationplaybook.yaml:12:3: Field 'name' is defined multiple times in Component",
+	}, lines)
+
+	// syntax errors are reported, not only type errors
+	_, err = Decode([]byte("components: [\n"), "")
+	assert.ErrorContains(t, err, "Error parsing yaml")
+}
+
+func TestJSONSchema(t *testing.T) {
+	data, err := JSONSchema()
+	assert.NoError(t, err)
+
+	var schema map[string]interface{}
+	assert.NoError(t, json.Unmarshal(data, &schema))
+	assert.Equal(t, SchemaVersion, schema["$schema"])
+	assert.Equal(t, Kind, schema["title"])
+
+	// every version which can be read has a schema
+	ref := func(name string) interface{} { return map[string]interface{}{"$ref": "#/definitions/" + name} }
+	assert.Equal(t, []interface{}{ref("Playbook"), ref("v1beta1.Playbook")}, schema["anyOf"])
+
+	defs := schema["definitions"].(map[string]interface{})
+	apiVersion := func(pb string) interface{} {
+		return defs[pb].(map[string]interface{})["properties"].(map[string]interface{})["apiVersion"].(map[string]interface{})["enum"]
+	}
+	assert.Equal(t, []interface{}{ApiVersionV1}, apiVersion("Playbook"))
+	assert.Equal(t, []interface{}{ApiVersionV1beta1}, apiVersion("v1beta1.Playbook"))
+
+	// dependsOn is a list of names since v1, only the types containing it differ
+	dependsOn := func(c string) interface{} {
+		return defs[c].(map[string]interface{})["properties"].(map[string]interface{})["dependsOn"].(map[string]interface{})["items"]
+	}
+	assert.Equal(t, map[string]interface{}{"type": "string"}, dependsOn("Component"))
+	assert.Equal(t, ref("v1beta1.DependsSpec"), dependsOn("v1beta1.Component"))
+	assert.Equal(t, "object", defs["v1beta1.DependsSpec"].(map[string]interface{})["type"])
+	assert.Contains(t, defs, "Conditions")
+	assert.NotContains(t, defs, "v1beta1.Conditions")
+
+	component := defs["Component"].(map[string]interface{})
+	assert.Equal(t, []interface{}{"name"}, component["required"])
+	assert.Equal(t, false, component["additionalProperties"])
+
+	name := component["properties"].(map[string]interface{})["name"].(map[string]interface{})
+	assert.Equal(t, "string", name["type"])
+	assert.Equal(t, "Name is the mandatory name of the component", name["description"])
+
+	conditions := defs["Conditions"].(map[string]interface{})
+	assert.Len(t, conditions["oneOf"], len(conditionTypes))
+
+	// every type is documented
+	for name, def := range playbookSchemas[ApiVersion].Definitions {
+		assert.NotEmpty(t, def.Description, name)
+	}
+}
+
+func TestDecodeSchema(t *testing.T) {
+	y := `apiVersion: kustomizeplaybook.world-direct.at/v2
+kind: KustomizationPlaybook
+prerequisites:
+- message: nothing
+- exec:
+    command: "true"
+  nodes:
+    minReady: 3
+vars:
+- name: A
+  type: float
+components:
+- dependsOn:
+  - {}
+  readinessConditions:
+  - nodes:
+      minReady: many
+`
+
+	_, err := Decode([]byte(y), "")
+	assert.Error(t, err)
+
+	lines := strings.Split(err.Error(), "\n")
+	assert.Equal(t, []string{
+		"1:13: Expected one of " + ApiVersion + ", not 'kustomizeplaybook.world-direct.at/v2'",
+		"4:3: Conditions needs to have one of " + strings.Join(conditionTypes, ", "),
+		"5:3: Conditions must have only one of " + strings.Join(conditionTypes, ", ") + ", not exec and nodes",
+		"11:9: Expected one of string, int, bool, ip, cidr, email, url, not 'float'",
+		"14:5: Expected a string, not a mapping",
+		"17:17: Expected an integer, not 'many'",
+		"13:3: Component needs to have name",
+	}, lines)
+}
+
+func TestDecodeVersionSchema(t *testing.T) {
+	// the syntax of v1 isn't valid in v1beta1, and the other way round
+	_, err := Decode([]byte(`apiVersion: kustomizeplaybook.world-direct.at/v1beta1
+kind: KustomizationPlaybook
+components:
+- name: a
+- name: b
+  dependsOn: [a]
+`), "")
+	assert.EqualError(t, err, "6:15: Expected a mapping for DependsSpec, not 'a'")
+
+	_, err = Decode([]byte(`apiVersion: kustomizeplaybook.world-direct.at/v1
+kind: KustomizationPlaybook
+components:
+- name: a
+- name: b
+  dependsOn: [{name: a}]
+`), "")
+	assert.EqualError(t, err, "6:15: Expected a string, not a mapping")
+
+	_, err = Decode([]byte(`apiVersion: kustomizeplaybook.world-direct.at/v1beta1
+kind: KustomizationPlaybook
+components:
+- name: a
+- name: b
+  dependsOn: [{nme: a}]
+`), "")
+	assert.EqualError(t, err, "6:16: Unknown field 'nme' in DependsSpec, did you mean 'name'?")
+}
+
+func TestConvert(t *testing.T) {
+	v1beta1 := `# the playbook
+apiVersion: kustomizeplaybook.world-direct.at/v1beta1
+kind: KustomizationPlaybook
+components:
+- name: crds
+- name: app # the app
+  dependsOn:
+  # needs the crds
+  - name: crds
+`
+
+	v1, err := Convert([]byte(v1beta1), ApiVersion)
+	assert.NoError(t, err)
+	assert.Equal(t, `# the playbook
+apiVersion: kustomizeplaybook.world-direct.at/v1
+kind: KustomizationPlaybook
+strictEnvsubst: false
+components:
+- name: crds
+- name: app # the app
+  dependsOn:
+  # needs the crds
+  - crds
+`, string(v1))
+
+	// converting to the same version doesn't change anything
+	same, err := Convert(v1, ApiVersion)
+	assert.NoError(t, err)
+	assert.Equal(t, v1, same)
+
+	// only the converted parts are changed, strictEnvsubst is kept
+	back, err := Convert(v1, ApiVersionV1beta1)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Replace(v1beta1, "kind: KustomizationPlaybook\n", "kind: KustomizationPlaybook\nstrictEnvsubst: false\n", 1), string(back))
+
+	// the default of strictEnvsubst of the version is kept
+	back, err = Convert([]byte("apiVersion: kustomizeplaybook.world-direct.at/v1\nkind: KustomizationPlaybook"), ApiVersionV1beta1)
+	assert.NoError(t, err)
+	assert.Equal(t, "apiVersion: kustomizeplaybook.world-direct.at/v1beta1\nkind: KustomizationPlaybook\nstrictEnvsubst: true\n", string(back))
+
+	back, err = Convert([]byte("{apiVersion: kustomizeplaybook.world-direct.at/v1, kind: KustomizationPlaybook}\n"), ApiVersionV1beta1)
+	assert.NoError(t, err)
+	assert.Equal(t, "{apiVersion: kustomizeplaybook.world-direct.at/v1beta1, kind: KustomizationPlaybook, strictEnvsubst: true}\n", string(back))
+
+	flow := `apiVersion: "kustomizeplaybook.world-direct.at/v1beta1"
+kind: KustomizationPlaybook
+components:
+- name: app
+  dependsOn: [{name: crds}, { name: "db" }]
+`
+	v1, err = Convert([]byte(flow), ApiVersion)
+	assert.NoError(t, err)
+	assert.Equal(t, `apiVersion: "kustomizeplaybook.world-direct.at/v1"
+kind: KustomizationPlaybook
+strictEnvsubst: false
+components:
+- name: app
+  dependsOn: [crds, "db"]
+`, string(v1))
+
+	back, err = Convert(v1, ApiVersionV1beta1)
+	assert.NoError(t, err)
+	assert.Contains(t, string(back), `dependsOn: [name: crds, name: "db"]`)
+
+	pb, err := Decode(back, "pb.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, []DependsSpec{{Name: "crds"}, {Name: "db"}}, pb.Components[0].DependsOn)
+
+	_, err = Convert(v1, "kustomizeplaybook.world-direct.at/v2")
+	assert.ErrorContains(t, err, "Unknown apiVersion")
+}
+
+func TestDecodeYAML11Booleans(t *testing.T) {
+	for _, version := range ApiVersions() {
+		y := `apiVersion: ` + version + `
+kind: KustomizationPlaybook
+envsubst: yes
+components:
+- name: app
+  envsubst: On
+- name: db
+  envsubst: no
+`
+
+		pb, err := Decode([]byte(y), "pb.yaml")
+		if !assert.NoError(t, err, version) {
+			continue
+		}
+		assert.True(t, pb.Envsubst)
+		assert.True(t, pb.Components[0].Envsubst)
+		assert.False(t, pb.Components[1].Envsubst)
+	}
+
+	// quoted values are strings
+	_, err := Decode([]byte("envsubst: \"yes\"\n"), "pb.yaml")
+	assert.EqualError(t, err, "pb.yaml:1:11: Expected a boolean, not 'yes'")
+}
+
+func TestDecodeVersions(t *testing.T) {
+	y := `apiVersion: kustomizeplaybook.world-direct.at/v1beta1
+kind: KustomizationPlaybook
+components:
+- name: crds
+- name: app
+  dependsOn:
+  - name: crds
+  readinessConditions:
+  - exec: {}
+`
+
+	pb, err := Decode([]byte(y), "pb.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, ApiVersion, pb.ApiVersion)
+	assert.Equal(t, []DependsSpec{{Name: "crds"}}, pb.Components[1].DependsOn)
+	assert.Equal(t, []string{"pb.yaml: apiVersion " + ApiVersionV1beta1 + " is deprecated, convert the playbook to " + ApiVersion + " with the migrate command"}, pb.Warnings())
+
+	// positions refer to the original file
+	errs := pb.Validate()
+	assert.Len(t, errs, 1)
+	assert.Equal(t, "pb.yaml:9:5: An exec condition needs to have a command", errs[0].Error())
+
+	pb, err = Decode([]byte(strings.Replace(strings.Replace(y, "v1beta1", "v1", 1), "- name: crds\n  readiness", "- crds\n  readiness", 1)), "pb.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, []DependsSpec{{Name: "crds"}}, pb.Components[1].DependsOn)
+	assert.Empty(t, pb.Warnings())
+}
+
+func TestConditionEvaluated(t *testing.T) {
+	cs := ConditionSlice{
+		{Compare: &CompareCondition{Value: CompareOperant{ScalarValue: 3}, With: CompareOperant{ScalarValue: "3"}}},
+		{Message: "Not enough replicas", Compare: &CompareCondition{Value: CompareOperant{ScalarValue: 1}, With: CompareOperant{ScalarValue: 3}}},
+	}
+
+	var results []ConditionResult
+	ec := &EvalContext{Evaluated: func(result ConditionResult) {
+		results = append(results, result)
+	}}
+
+	ff, err := cs.IsFulfilled(context.Background(), ec)
+	assert.NoError(t, err)
+	assert.False(t, ff)
+
+	assert.Equal(t, []ConditionResult{
+		{Type: "compare", Fulfilled: true, Actual: "3", Expected: "3"},
+		{Type: "compare", Reason: "Not enough replicas: '1' is not equal to '3'", Actual: "1", Expected: "3"},
+	}, results)
+	assert.Equal(t, "1", ec.Actual)
+	assert.Equal(t, "3", ec.Expected)
+}
+
+func TestValidatePositions(t *testing.T) {
+	y := `apiVersion: kustomizeplaybook.world-direct.at/v1beta1
+kind: KustomizationPlaybook
+vars:
+- name: REPLICAS
+  pattern: "[0-9"
+components:
+- name: c1
+  dependsOn:
+  - name: c2
+- name: c2
+  readinessConditions:
+  - compare:
+      value:
+        objectValue:
+          apiVersion: v1
+          kind: Pod
+          name: p
+          goTemplate: "{{.status"
+      with:
+        scalarValue: x
+`
+
+	pb, err := Decode([]byte(y), "pb.yaml")
+	assert.NoError(t, err)
+
+	errs := pb.Validate()
+	assert.Len(t, errs, 3)
+	assert.Regexp(t, "^pb.yaml:4:3: Variable 'REPLICAS' has an invalid pattern", errs[0].Error())
+	assert.Regexp(t, "^pb.yaml:12:5: Invalid goTemplate", errs[1].Error())
+	assert.Regexp(t, "^pb.yaml:7:3: Dependency 'c2' of component 'c1' must not be before", errs[2].Error())
+}
+
+func TestValidateConditionShape(t *testing.T) {
+	y := `apiVersion: kustomizeplaybook.world-direct.at/v1beta1
+kind: KustomizationPlaybook
+components:
+- name: c1
+  kustomization: {}
+  readinessConditions:
+  - compare:
+      value:
+        scalarValue: a
+        objectValue:
+          apiVersion: v1
+          kind: Pod
+          name: p
+          goTemplate: "{{.status.phase}}"
+      with:
+        objectValue:
+          kind: Pod
+  - exec:
+      timeout: soon
+  - customResourceDefinition: {}
+  - objectAbsent:
+      kind: Pod
+  - objectAbsent:
+      apiVersion: v1
+      kind: Pod
+      name: p
+      selector: app=p
+  - serverVersion:
+      constraint: abc
+  - serverVersion:
+      constraint: ">= ${MIN_VERSION}"
+tools:
+  kubectl: ">= x"
+`
+
+	pb, err := Unmarshal([]byte(y))
+	assert.NoError(t, err)
+
+	var msgs []string
+	for _, err := range pb.Validate() {
+		msgs = append(msgs, err.Error())
+	}
+
+	assert.Equal(t, []string{
+		"7:5: Operant 'value' of compare: must not have both objectValue and scalarValue",
+		"7:5: Operant 'with' of compare: objectValue needs to have apiVersion, name, goTemplate",
+		"18:5: An exec condition needs to have a command",
+		"18:5: Invalid timeout 'soon' for exec condition: time: invalid duration \"soon\"",
+		"20:5: A customResourceDefinition condition needs to have a name",
+		"21:5: An objectAbsent condition needs to have apiVersion and kind",
+		"21:5: An objectAbsent condition needs to have either name or selector",
+		"23:5: An objectAbsent condition needs to have either name or selector",
+		"28:5: Invalid version constraint 'abc': could not parse \"abc\" as version",
+		"4:3: The kustomization of component 'c1' is empty",
+		"32:1: Tool kubectl: Invalid version constraint '>= x': could not parse \"x\" as version",
+	}, msgs)
+
+	// conditions which are not decoded are checked by Validate too
+	errs := (&Conditions{}).validate()
+	assert.Len(t, errs, 1)
+	assert.Equal(t, "A condition needs to have one of "+strings.Join(conditionTypes, ", "), errs[0].Error())
+
+	errs = (&Conditions{Exec: &ExecCondition{Command: "true"}, Nodes: &NodesCondition{}}).validate()
+	assert.Len(t, errs, 1)
+	assert.Equal(t, "A condition must have only one of "+strings.Join(conditionTypes, ", ")+", not exec and nodes", errs[0].Error())
+}
+
+func TestValidateComponentGraph(t *testing.T) {
+	pb := Playbook{
+		ApiVersion: ApiVersion,
+		Kind:       Kind,
+		Components: []Component{
+			{Name: "a", DependsOn: []DependsSpec{{Name: "c"}}},
+			{Name: "b", DependsOn: []DependsSpec{{Name: "a"}}},
+			{Name: "c", DependsOn: []DependsSpec{{Name: "b"}}},
+			{Name: "d", DependsOn: []DependsSpec{{Name: "d"}}},
+			{Name: "a"},
+		},
+	}
+
+	errs := pb.validateComponentGraph()
+	assert.Len(t, errs, 3)
+	assert.Equal(t, "Component 'a' is defined multiple times", errs[0].Error())
+	assert.Equal(t, "Dependency cycle: a -> c -> b -> a", errs[1].Error())
+	assert.Equal(t, "Dependency cycle: d -> d", errs[2].Error())
+}
+
+func TestValidatePaths(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "exists"), 0755))
+
+	pb := Playbook{Components: []Component{{
+		Name: "c",
+		Kustomization: UnmarshalKustomization(`
+resources:
+- exists
+- missing
+- ${DIR}/app
+- https://github.com/org/repo/manifests
+configMapGenerator:
+- name: cm
+  files:
+  - key=missing.txt
+`),
+	}}}
+
+	errs := pb.ValidatePaths(dir)
+	assert.Len(t, errs, 2)
+	assert.Equal(t, "Path 'missing' of component 'c' doesn't exist", errs[0].Error())
+	assert.Equal(t, "Path 'missing.txt' of component 'c' doesn't exist", errs[1].Error())
+}
+
 func assertKnownError(t *testing.T, errs []error, i int) *knownerror.KnownError {
 	e := errs[i]
 	assert.NotNil(t, e)
@@ -214,3 +1022,572 @@ components:
 	assert.Regexp(t, "must not be before", ke.Message) // must contain the name 'dependency'
 
 }
+
+func TestExecCondition_ExitCode(t *testing.T) {
+	ctx := context.Background()
+	ec := &EvalContext{Directory: t.TempDir()}
+
+	ok := &ExecCondition{Command: "true"}
+	isff, err := ok.IsFulfilled(ctx, ec)
+	assert.NoError(t, err)
+	assert.True(t, isff)
+
+	fail := &ExecCondition{Command: "sh", Args: []string{"-c", "echo not ready; exit 3"}}
+	isff, err = fail.IsFulfilled(ctx, ec)
+	assert.NoError(t, err)
+	assert.False(t, isff)
+	assert.Regexp(t, "code 3", ec.Reason)
+	assert.Regexp(t, "not ready", ec.Reason)
+}
+
+func TestExecCondition_Env(t *testing.T) {
+	ec := &EvalContext{
+		Directory:  t.TempDir(),
+		KubeConfig: "/kubeconfig",
+		Envs:       map[string]string{"CFG_VAR": "fromenvs"},
+	}
+
+	e := &ExecCondition{
+		Command: "sh",
+		Args:    []string{"-c", `test "$CFG_VAR" = fromenvs && test "$KUBECONFIG" = /kubeconfig && test "$OWN" = own`},
+		Env:     map[string]string{"OWN": "own"},
+	}
+
+	isff, err := e.IsFulfilled(context.Background(), ec)
+	assert.NoError(t, err)
+	assert.True(t, isff)
+}
+
+func TestExecCondition_Timeout(t *testing.T) {
+	ec := &EvalContext{Directory: t.TempDir()}
+	e := &ExecCondition{Command: "sleep", Args: []string{"5"}, Timeout: "100ms"}
+
+	isff, err := e.IsFulfilled(context.Background(), ec)
+	assert.NoError(t, err)
+	assert.False(t, isff)
+	assert.Regexp(t, "timed out", ec.Reason)
+}
+
+func TestExecCondition_NotFound(t *testing.T) {
+	ec := &EvalContext{Directory: t.TempDir()}
+	e := &ExecCondition{Command: "./doesnotexist.sh"}
+
+	_, err := e.IsFulfilled(context.Background(), ec)
+	assert.Error(t, err)
+}
+
+func TestExecCondition_RelativeDirectory(t *testing.T) {
+	dir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(dir, "pb"), 0755)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, "pb", "check.sh"), []byte("#!/bin/sh\ntest -f check.sh\n"), 0755)
+	assert.NoError(t, err)
+
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	// like for kustomizepb apply pb
+	ec := &EvalContext{Directory: "pb"}
+	e := &ExecCondition{Command: "./check.sh"}
+
+	isff, err := e.IsFulfilled(context.Background(), ec)
+	assert.NoError(t, err)
+	assert.True(t, isff, ec.Reason)
+}
+
+func TestVersionConstraint(t *testing.T) {
+	tests := []struct {
+		constraint VersionConstraint
+		version    string
+		expected   bool
+	}{
+		{">= 1.24, < 1.27", "v1.25.3", true},
+		{">= 1.24, < 1.27", "v1.27.0", false},
+		{">= 1.24, < 1.27", "v1.23.9+k3s1", false},
+		{"1.24", "v1.24.0", true},
+		{"1.24", "v1.26.1", true},
+		{"1.24", "v1.23.9", false},
+		{"= 1.25.3", "v1.25.3-gke.100", true},
+		{"!= 1.25.3", "v1.25.3", false},
+		{"> 1.25", "v1.25.1", true},
+		{"<= 1.25", "v1.25.1", false},
+		{"", "v1.0.0", true},
+	}
+
+	for _, tt := range tests {
+		ok, err := tt.constraint.Check(tt.version)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.expected, ok, "%s %s", tt.constraint, tt.version)
+	}
+
+	_, err := VersionConstraint(">= one").Check("v1.25.0")
+	assert.Error(t, err)
+}
+
+func TestClusterIdentity_WithEnvs(t *testing.T) {
+	var ci *ClusterIdentity
+	assert.Nil(t, ci.WithEnvs(map[string]string{}))
+
+	res := ci.WithEnvs(map[string]string{EnvClusterUID: "uid"})
+	assert.Equal(t, &ClusterIdentity{KubeSystemUID: "uid"}, res)
+
+	ci = &ClusterIdentity{KubeSystemUID: "pbuid", Server: "https://pb:6443"}
+	res = ci.WithEnvs(map[string]string{EnvClusterServer: "https://env:6443"})
+	assert.Equal(t, &ClusterIdentity{KubeSystemUID: "pbuid", Server: "https://env:6443"}, res)
+	assert.Equal(t, "https://pb:6443", ci.Server) // must not be modified
+}
+
+func TestLoadPB_ClusterIdentity(t *testing.T) {
+	y := `
+apiVersion: kustomizeplaybook.world-direct.at/v1beta1
+kind: KustomizationPlaybook
+clusterIdentity:
+  kubeSystemUID: 0b5c6c4e-5bd1-4d3c-9d43-6f4f1c7d0c3e
+  configMap:
+    namespace: default
+    name: cluster-info
+    labels:
+      env: prod
+`
+	pb, err := Unmarshal([]byte(y))
+	assert.NoError(t, err)
+	assert.NotNil(t, pb.ClusterIdentity)
+	assert.Equal(t, "0b5c6c4e-5bd1-4d3c-9d43-6f4f1c7d0c3e", pb.ClusterIdentity.KubeSystemUID)
+	assert.Equal(t, "cluster-info", pb.ClusterIdentity.ConfigMap.Name)
+	assert.Equal(t, "prod", pb.ClusterIdentity.ConfigMap.Labels["env"])
+}
+
+func fluxObject(generation int64, observedGeneration int64, conditions ...map[string]interface{}) *unstructured.Unstructured {
+	var cs []interface{}
+	for _, c := range conditions {
+		cs = append(cs, c)
+	}
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"status": map[string]interface{}{
+			"observedGeneration": observedGeneration,
+			"conditions":         cs,
+		},
+	}}
+	obj.SetKind("HelmRelease")
+	obj.SetName("release")
+	obj.SetNamespace("ns")
+	obj.SetGeneration(generation)
+
+	return obj
+}
+
+func TestFluxReadiness(t *testing.T) {
+	ec := &EvalContext{}
+
+	ready, err := fluxReadiness(ec, fluxObject(2, 2, map[string]interface{}{"type": "Ready", "status": "True", "observedGeneration": int64(2)}))
+	assert.NoError(t, err)
+	assert.True(t, ready)
+
+	ready, err = fluxReadiness(ec, fluxObject(3, 2, map[string]interface{}{"type": "Ready", "status": "True"}))
+	assert.NoError(t, err)
+	assert.False(t, ready)
+	assert.Regexp(t, "generation 3", ec.Reason)
+
+	ready, err = fluxReadiness(ec, fluxObject(2, 2, map[string]interface{}{"type": "Ready", "status": "False", "reason": "InstallFailed", "message": "chart not found"}))
+	assert.NoError(t, err)
+	assert.False(t, ready)
+	assert.Regexp(t, "chart not found", ec.Reason)
+
+	_, err = fluxReadiness(ec, fluxObject(2, 2, map[string]interface{}{"type": "Stalled", "status": "True", "reason": "RetriesExceeded", "message": "install retries exhausted"}))
+	assert.Error(t, err)
+	assert.Regexp(t, "install retries exhausted", err.Error())
+
+	// a stalled status of the previous generation is ignored, after the fixed object has been applied
+	ready, err = fluxReadiness(ec, fluxObject(3, 2, map[string]interface{}{"type": "Stalled", "status": "True", "reason": "RetriesExceeded", "message": "install retries exhausted"}))
+	assert.NoError(t, err)
+	assert.False(t, ready)
+	assert.Regexp(t, "generation 3", ec.Reason)
+}
+
+func TestObjectsAbsent(t *testing.T) {
+	ec := &EvalContext{}
+	assert.True(t, objectsAbsent(ec, "Namespace", nil))
+
+	obj := unstructured.Unstructured{}
+	obj.SetName("legacy")
+	assert.False(t, objectsAbsent(ec, "Namespace", []unstructured.Unstructured{obj}))
+	assert.Equal(t, "Namespace legacy exists", ec.Reason)
+
+	now := metav1.Now()
+	obj.SetDeletionTimestamp(&now)
+	assert.False(t, objectsAbsent(ec, "Namespace", []unstructured.Unstructured{obj}))
+	assert.Equal(t, "Namespace legacy is being deleted", ec.Reason)
+}
+
+func TestReferencedVars(t *testing.T) {
+	refs, err := ReferencedVars("a: ${A}/32\nb: $B\nc: ${C:-default}\nd: ${D,,}\ne: ${A}")
+	assert.NoError(t, err)
+	assert.Equal(t, []VarRef{
+		{Name: "A"},
+		{Name: "C", HasDefault: true},
+		{Name: "D"},
+		{Name: "A"},
+	}, refs)
+}
+
+func TestEnvSubst_Strict(t *testing.T) {
+	y := `
+apiVersion: kustomizeplaybook.world-direct.at/v1beta1
+kind: KustomizationPlaybook
+strictEnvsubst: true
+components:
+- name: c1
+  envsubst: true
+  kustomization:
+    namespace: ${NS}
+    commonLabels:
+      ip: ${IP}/32
+      env: ${ENV:-dev}
+- name: c2
+  envsubst: true
+  kustomization:
+    namespace: ${NS2}
+- name: c3
+  kustomization:
+    namespace: ${NOTSUBSTITUTED}
+`
+	pb, err := Unmarshal([]byte(y))
+	assert.NoError(t, err)
+	assert.True(t, pb.IsStrictEnvsubst())
+
+	err = pb.EnvSubst(map[string]string{"NS": "ns"})
+	assert.Error(t, err)
+	assert.Equal(t, "Unresolved variables in component 'c1': IP; component 'c2': NS2", err.Error())
+
+	err = pb.EnvSubst(map[string]string{"NS": "ns", "IP": "10.0.0.1", "NS2": ""})
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1/32", pb.Components[0].Kustomization["commonLabels"].(map[interface{}]interface{})["ip"])
+	assert.Equal(t, "dev", pb.Components[0].Kustomization["commonLabels"].(map[interface{}]interface{})["env"])
+
+	vars, err := pb.ReferencedVars()
+	assert.NoError(t, err)
+	assert.Empty(t, vars) // all substituted
+}
+
+func TestEnvSubst_NotStrictForV1beta1(t *testing.T) {
+	pb := &Playbook{ApiVersion: ApiVersionV1beta1}
+	assert.False(t, pb.IsStrictEnvsubst())
+
+	// v1beta1 playbooks are converted to v1 when they are decoded
+	pb, err := Decode([]byte(`
+apiVersion: kustomizeplaybook.world-direct.at/v1beta1
+kind: KustomizationPlaybook
+components:
+- name: c1
+  envsubst: true
+  kustomization:
+    namespace: ${NS}
+`), "pb.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, ApiVersion, pb.ApiVersion)
+	assert.False(t, pb.IsStrictEnvsubst())
+	assert.NoError(t, pb.CheckUnresolvedVars(map[string]string{}))
+
+	pb, err = Decode([]byte(`
+apiVersion: kustomizeplaybook.world-direct.at/v1beta1
+kind: KustomizationPlaybook
+strictEnvsubst: true
+`), "pb.yaml")
+	assert.NoError(t, err)
+	assert.True(t, pb.IsStrictEnvsubst())
+}
+
+func TestPlaybookVars(t *testing.T) {
+	y := `
+apiVersion: kustomizeplaybook.world-direct.at/v1beta1
+kind: KustomizationPlaybook
+components:
+- name: c1
+  envsubst: true
+  kustomization:
+    namespace: ${NS}
+    commonLabels:
+      env: ${ENV:-dev}
+- name: c2
+  envsubst: true
+  kustomization:
+    namespace: ${NS}
+    nameSuffix: ${SUFFIX}${SUFFIX}
+`
+	pb, err := Unmarshal([]byte(y))
+	assert.NoError(t, err)
+
+	vars, err := pb.ReferencedVars()
+	assert.NoError(t, err)
+	assert.Equal(t, []VarUsage{
+		{Name: "ENV", Components: []string{"c1"}, HasDefault: true},
+		{Name: "NS", Components: []string{"c1", "c2"}},
+		{Name: "SUFFIX", Components: []string{"c2"}},
+	}, vars)
+}
+
+func TestVarDeclarations(t *testing.T) {
+	y := `
+apiVersion: kustomizeplaybook.world-direct.at/v1beta1
+kind: KustomizationPlaybook
+vars:
+- name: CFG_IP_INGRESS
+  description: IP address of the ingress
+  type: ip
+  required: true
+- name: CFG_REPLICAS
+  type: int
+  default: 3
+- name: CFG_ENV
+  pattern: dev|prod
+  default: dev
+- name: CFG_EMAIL
+  type: email
+`
+	pb, err := Unmarshal([]byte(y))
+	assert.NoError(t, err)
+	assert.Empty(t, pb.Validate())
+	assert.Len(t, pb.Vars, 4)
+	assert.Equal(t, "IP address of the ingress", pb.Vars[0].Description)
+	assert.Equal(t, "3", *pb.Vars[1].Default)
+
+	values, errs := pb.ResolveVars(map[string]string{"CFG_IP_INGRESS": "10.11.1.22"})
+	assert.Empty(t, errs)
+	assert.Equal(t, map[string]string{"CFG_IP_INGRESS": "10.11.1.22", "CFG_REPLICAS": "3", "CFG_ENV": "dev"}, values)
+
+	_, errs = pb.ResolveVars(map[string]string{"CFG_IP_INGRESS": "foo", "CFG_REPLICAS": "three", "CFG_ENV": "staging", "CFG_EMAIL": "test@test.com"})
+	assert.Len(t, errs, 3)
+	assert.Regexp(t, "CFG_IP_INGRESS", errs[0].Error())
+	assert.Regexp(t, "CFG_REPLICAS", errs[1].Error())
+	assert.Regexp(t, "pattern", errs[2].Error())
+
+	_, errs = pb.ResolveVars(map[string]string{})
+	assert.Len(t, errs, 1)
+	assert.Regexp(t, "'CFG_IP_INGRESS' is required", errs[0].Error())
+}
+
+func TestVarDeclarations_Validation(t *testing.T) {
+	y := `
+apiVersion: kustomizeplaybook.world-direct.at/v1beta1
+kind: KustomizationPlaybook
+vars:
+- name: B
+  pattern: "("
+- name: C
+  type: cidr
+  default: 10.0.0.1
+- name: C
+`
+	pb, err := Unmarshal([]byte(y))
+	assert.NoError(t, err)
+
+	errs := pb.Validate()
+	assert.Len(t, errs, 3)
+	assert.Regexp(t, "invalid pattern", errs[0].Error())
+	assert.Regexp(t, "Default of variable 'C'", errs[1].Error())
+	assert.Regexp(t, "'C' is declared multiple times", errs[2].Error())
+
+	// unknown types are rejected by the schema
+	_, err = Unmarshal([]byte("vars:\n- name: A\n  type: float\n"))
+	assert.ErrorContains(t, err, "not 'float'")
+
+	pb = &Playbook{ApiVersion: ApiVersion, Kind: Kind, Vars: []VarDeclaration{{Name: "A", Type: "float"}}}
+	errs = pb.Validate()
+	assert.Len(t, errs, 1)
+	assert.Regexp(t, "unknown type 'float'", errs[0].Error())
+}
+
+func TestVarTypes(t *testing.T) {
+	tests := []struct {
+		vt    VarType
+		value string
+		valid bool
+	}{
+		{VarTypeString, "anything", true},
+		{VarTypeInt, "42", true},
+		{VarTypeInt, "4.2", false},
+		{VarTypeBool, "true", true},
+		{VarTypeBool, "yes", false},
+		{VarTypeIP, "10.11.1.22", true},
+		{VarTypeIP, "::1", true},
+		{VarTypeIP, "10.11.1.22/32", false},
+		{VarTypeCIDR, "10.11.1.0/24", true},
+		{VarTypeCIDR, "10.11.1.0", false},
+		{VarTypeEmail, "test@test.com", true},
+		{VarTypeEmail, "Test <test@test.com>", false},
+		{VarTypeURL, "https://example.com/path", true},
+		{VarTypeURL, "example.com", false},
+	}
+
+	for _, tt := range tests {
+		err := tt.vt.validateValue(tt.value)
+		assert.Equal(t, tt.valid, err == nil, "%s %s", tt.vt, tt.value)
+	}
+}
+
+func TestEnvSubst_Conditions(t *testing.T) {
+	y := `
+apiVersion: kustomizeplaybook.world-direct.at/v1beta1
+kind: KustomizationPlaybook
+envsubst: true
+prerequisites:
+- nodes:
+    name: ${CFG_NODE}
+components:
+- name: innodb-cluster
+  envsubst: true
+  kustomization:
+    namespace: ${CFG_NS}
+  applyConditions:
+  - objectAbsent:
+      apiVersion: v1
+      kind: Namespace
+      name: ${CFG_NS}-old
+  readinessConditions:
+  - compare:
+      value:
+        objectValue:
+          apiVersion: mysql.oracle.com/v2
+          kind: InnoDBCluster
+          namespace: ${CFG_NS}
+          name: innodbclu1
+          goTemplate: "{{.status.cluster.status}}"
+      with:
+        scalarValue: ${CFG_STATUS}
+  - serviceReady:
+      name: innodbclu1
+      namespace: ${CFG_NS}
+- name: notsubstituted
+  readinessConditions:
+  - serviceReady:
+      name: service
+      namespace: ${CFG_NS}
+`
+	pb, err := Unmarshal([]byte(y))
+	assert.NoError(t, err)
+
+	vars, err := pb.ReferencedVars()
+	assert.NoError(t, err)
+	assert.Equal(t, []VarUsage{
+		{Name: "CFG_NODE", Components: []string{PrerequisitesScope}},
+		{Name: "CFG_NS", Components: []string{"innodb-cluster"}},
+		{Name: "CFG_STATUS", Components: []string{"innodb-cluster"}},
+	}, vars)
+
+	err = pb.EnvSubst(map[string]string{"CFG_NODE": "node1", "CFG_NS": "innodb-prod", "CFG_STATUS": "ONLINE"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "node1", pb.Prerequisites[0].Nodes.Name)
+
+	c := pb.Components[0]
+	assert.Equal(t, "innodb-prod", c.Kustomization["namespace"])
+	assert.Equal(t, "innodb-prod-old", c.ApplyConditions[0].ObjectAbsent.Name)
+	assert.Equal(t, "innodb-prod", c.ReadinessConditions[0].Compare.Value.ObjectValue.Namespace)
+	assert.Equal(t, GoTemplateSpec("{{.status.cluster.status}}"), c.ReadinessConditions[0].Compare.Value.ObjectValue.GoTemplate)
+	assert.Equal(t, "ONLINE", c.ReadinessConditions[0].Compare.With.ScalarValue)
+	assert.Equal(t, "innodb-prod", c.ReadinessConditions[1].ServiceReady.Namespace)
+
+	assert.Equal(t, "${CFG_NS}", pb.Components[1].ReadinessConditions[0].ServiceReady.Namespace)
+}
+
+func TestVarSources_Validation(t *testing.T) {
+	y := `
+apiVersion: kustomizeplaybook.world-direct.at/v1beta1
+kind: KustomizationPlaybook
+varSources:
+- secret:
+    namespace: db
+    name: innodb-passwords
+  vars:
+    CFG_INNODB_ROOTPASSWORD: rootPassword
+- configMap:
+    namespace: default
+    name: settings
+  context: management
+- context: management
+- secret:
+    namespace: db
+`
+	pb, err := Unmarshal([]byte(y))
+	assert.NoError(t, err)
+	assert.Equal(t, "rootPassword", pb.VarSources[0].Vars["CFG_INNODB_ROOTPASSWORD"])
+	assert.Equal(t, "management", pb.VarSources[1].Context)
+
+	errs := pb.Validate()
+	assert.Len(t, errs, 2)
+	assert.Regexp(t, "secret or configMap", errs[0].Error())
+	assert.Regexp(t, "name", errs[1].Error())
+}
+
+func TestOutputs(t *testing.T) {
+	y := `
+apiVersion: kustomizeplaybook.world-direct.at/v1beta1
+kind: KustomizationPlaybook
+strictEnvsubst: true
+components:
+- name: metallb
+  outputs:
+  - name: CFG_INGRESS_IP
+    objectValue:
+      apiVersion: v1
+      kind: Service
+      namespace: ingress
+      name: ingress-nginx
+      goTemplate: "{{(index .status.loadBalancer.ingress 0).ip}}"
+- name: ingress
+  dependsOn:
+  - name: metallb
+- name: dns
+  envsubst: true
+  dependsOn:
+  - name: ingress
+  kustomization:
+    commonAnnotations:
+      ip: ${CFG_INGRESS_IP}
+`
+	pb, err := Unmarshal([]byte(y))
+	assert.NoError(t, err)
+	assert.Empty(t, pb.Validate())
+	assert.Equal(t, map[string]string{"CFG_INGRESS_IP": "metallb"}, pb.OutputProducers())
+	assert.Equal(t, GoTemplateSpec("{{(index .status.loadBalancer.ingress 0).ip}}"), pb.Components[0].Outputs[0].ObjectValue.GoTemplate)
+	assert.False(t, pb.Components[0].Outputs[0].IsSensitive())
+	assert.True(t, Output{ObjectValue: ObjectValueOperant{ApiVersion: "v1", Kind: "Secret"}}.IsSensitive())
+
+	// outputs are resolved at runtime
+	assert.NoError(t, pb.CheckUnresolvedVars(map[string]string{}))
+
+	dns := pb.Components[2]
+	err = dns.EnvSubst(map[string]string{}, true)
+	assert.Error(t, err)
+	assert.Regexp(t, "CFG_INGRESS_IP", err.Error())
+
+	err = dns.EnvSubst(map[string]string{"CFG_INGRESS_IP": "10.11.1.22"}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.11.1.22", dns.Kustomization["commonAnnotations"].(map[interface{}]interface{})["ip"])
+}
+
+func TestOutputs_Validation(t *testing.T) {
+	y := `
+apiVersion: kustomizeplaybook.world-direct.at/v1beta1
+kind: KustomizationPlaybook
+components:
+- name: producer
+  outputs:
+  - name: OUT
+  - name: OUT
+- name: consumer
+  envsubst: true
+  kustomization:
+    namespace: ${OUT}
+`
+	pb, err := Unmarshal([]byte(y))
+	assert.NoError(t, err)
+
+	errs := pb.Validate()
+	assert.Len(t, errs, 2)
+	assert.Regexp(t, "already defined", errs[0].Error())
+	assert.Regexp(t, "'consumer' uses output 'OUT', so it needs to depend on component 'producer'", errs[1].Error())
+}
@@ -2,29 +2,231 @@ package playbook
 
 import (
 	"context"
+	"time"
 
 	"github.com/gprossliner/kustomizepb/kubeaccess"
 )
 
 const (
-	ApiVersion = "kustomizeplaybook.world-direct.at/v1beta1"
+	ApiVersionV1      = "kustomizeplaybook.world-direct.at/v1"
+	ApiVersionV1beta1 = "kustomizeplaybook.world-direct.at/v1beta1"
+
+	// ApiVersion is the version of the types of this package, other versions are converted to it
+	ApiVersion = ApiVersionV1
 	Kind       = "KustomizationPlaybook"
+
+	// EnvClusterUID and EnvClusterServer can be set in the envfile to pin the cluster identity
+	EnvClusterUID    = "KUSTOMIZEPB_CLUSTER_UID"
+	EnvClusterServer = "KUSTOMIZEPB_CLUSTER_SERVER"
+
+	// DefaultExecTimeout is used for exec conditions without a timeout
+	DefaultExecTimeout = time.Minute
 )
 
+// Playbook is the content of the kustomizationplaybook.yaml file
 type Playbook struct {
-	ApiVersion    string         `yaml:"apiVersion"`
-	Kind          string         `yaml:"kind"`
+
+	// ApiVersion is the version of the playbook format
+	ApiVersion string `yaml:"apiVersion"`
+
+	// Kind is always KustomizationPlaybook
+	Kind string `yaml:"kind"`
+
+	// Prerequisites are the conditions that need to be fulfilled before any component is applied
 	Prerequisites ConditionSlice `yaml:"prerequisites"`
-	Components    []Component    `yaml:"components"`
+
+	// Components are the units of the playbook, which are applied in the order of their dependencies
+	Components []Component `yaml:"components"`
+
+	// ClusterIdentity pins the cluster the playbook may be applied to
+	ClusterIdentity *ClusterIdentity `yaml:"clusterIdentity"`
+
+	// Envsubst can be set to true to perform envsubst on the prerequisites
+	Envsubst bool `yaml:"envsubst"`
+
+	// Vars declares the variables used for envsubst
+	Vars []VarDeclaration `yaml:"vars"`
+
+	// VarSources read variables from Secrets and ConfigMaps in a cluster
+	VarSources []VarSource `yaml:"varSources"`
+
+	// StrictEnvsubst fails on variables which are not defined, see IsStrictEnvsubst for the default
+	StrictEnvsubst *bool `yaml:"strictEnvsubst"`
+
+	// Tools specifies the required versions of the local tools
+	Tools Tools `yaml:"tools"`
+
+	// Includes are other playbooks, whose components are applied before the own components
+	Includes []Include `yaml:"includes"`
+
+	// Clusters are the clusters components and conditions can be applied to by name,
+	// besides the target cluster given on the command line
+	Clusters map[string]Cluster `yaml:"clusters"`
+
+	// Profiles are overlays of the playbook by name, which are selected by --profile
+	Profiles map[string]Profile `yaml:"profiles"`
+
+	// positions are the positions of the top level fields
+	positions map[string]Position
+
+	// warnings are reported when the playbook is loaded, like for deprecated versions
+	warnings []string
+}
+
+// Profile is an overlay of the playbook, like for an environment
+type Profile struct {
+
+	// Vars set the default values of variables
+	Vars map[string]string `yaml:"vars"`
+
+	// Components are the overlays of the components by name.
+	// The name of a component with forEach or matrix applies to all instances.
+	Components map[string]ProfileComponent `yaml:"components"`
+}
+
+// ProfileComponent is the overlay of a component
+type ProfileComponent struct {
+
+	// Disabled components are removed, and dependencies on them are ignored
+	Disabled bool `yaml:"disabled"`
+
+	// ReadinessConditions replace the readinessConditions of the component, if set
+	ReadinessConditions ConditionSlice `yaml:"readinessConditions"`
+
+	// AddReadinessConditions are added to the readinessConditions of the component
+	AddReadinessConditions ConditionSlice `yaml:"addReadinessConditions"`
+
+	// Kustomization is a JSON merge patch (RFC 7386) for the kustomization of the component
+	Kustomization Kustomization `yaml:"kustomization"`
+}
+
+// Cluster references a context of a kubeconfig
+type Cluster struct {
+
+	// Context is the name of the kubeconfig context, it defaults to the target context
+	Context string `yaml:"context"`
+
+	// KubeConfig is the path of the kubeconfig file, it defaults to the target kubeconfig
+	KubeConfig string `yaml:"kubeconfig"`
+
+	// Identity pins the cluster, it's verified when the cluster is used for the first time
+	Identity *ClusterIdentity `yaml:"identity"`
+}
+
+// Include references the directory of another playbook
+type Include struct {
+
+	// Name is the prefix of the included components, like platform/cert-manager
+	Name string `yaml:"name"`
+
+	// Path is the directory of the playbook, relative to this playbook
+	Path string `yaml:"path"`
+}
+
+// VarDeclaration declares a variable, which is validated before anything is rendered
+type VarDeclaration struct {
+
+	// Name is the mandatory name of the variable
+	Name string `yaml:"name"`
+
+	// Description is for documentation only
+	Description string `yaml:"description"`
+
+	// Default is used if the variable is not provided
+	Default *string `yaml:"default"`
+
+	// Required variables need to have a non-empty value
+	Required bool `yaml:"required"`
+
+	// Type is one of the VarType constants, it defaults to string
+	Type VarType `yaml:"type"`
+
+	// Pattern is a regular expression the whole value needs to match
+	Pattern string `yaml:"pattern"`
+
+	pos Position
+}
+
+type VarType string
+
+// VarSource reads variables from the keys of a Secret or ConfigMap.
+// Values from Secrets are sensitive, and never printed.
+type VarSource struct {
+
+	// Secret reads the variables from a Secret
+	Secret *NamespacedName `yaml:"secret"`
+
+	// ConfigMap reads the variables from a ConfigMap
+	ConfigMap *NamespacedName `yaml:"configMap"`
+
+	// Context is the kubeconfig context of the cluster, it defaults to the target cluster
+	Context string `yaml:"context"`
+
+	// Vars maps variable names to keys, if empty every key is read as a variable
+	Vars map[string]string `yaml:"vars"`
+
+	pos Position
+}
+
+// NamespacedName references an object in a namespace
+type NamespacedName struct {
+	Namespace string `yaml:"namespace"`
+	Name      string `yaml:"name"`
+}
+
+const (
+	VarTypeString VarType = "string"
+	VarTypeInt    VarType = "int"
+	VarTypeBool   VarType = "bool"
+	VarTypeIP     VarType = "ip"
+	VarTypeCIDR   VarType = "cidr"
+	VarTypeEmail  VarType = "email"
+	VarTypeURL    VarType = "url"
+)
+
+// Tools are the version constraints for the local binaries
+type Tools struct {
+
+	// Kustomize is the version constraint for kustomize, like ">= 5.0"
+	Kustomize VersionConstraint `yaml:"kustomize"`
+
+	// Kubectl is the version constraint for kubectl, like ">= 1.25"
+	Kubectl VersionConstraint `yaml:"kubectl"`
+}
+
+// ClusterIdentity identifies a cluster. All fields which are set need to match.
+// The identity of the current cluster can be printed by the identity command
+type ClusterIdentity struct {
+
+	// KubeSystemUID is the UID of the kube-system namespace
+	KubeSystemUID string `yaml:"kubeSystemUID,omitempty"`
+
+	// Server is the URL of the API server
+	Server string `yaml:"server,omitempty"`
+
+	// ConfigMap is a sentinel ConfigMap that needs to exist with the given labels
+	ConfigMap *ConfigMapIdentity `yaml:"configMap,omitempty"`
+}
+
+// ConfigMapIdentity is a ConfigMap identifying a cluster
+type ConfigMapIdentity struct {
+	Namespace string            `yaml:"namespace"`
+	Name      string            `yaml:"name"`
+	Labels    map[string]string `yaml:"labels"`
 }
 
 type ConditionSlice []Conditions
 
+// Component is a named unit of the playbook, consisting of a kustomization and conditions
 type Component struct {
 
 	// Name is the mandatory name of the component
 	Name string `yaml:"name"`
 
+	// Cluster is the name of the cluster in the clusters map the component is applied to,
+	// it defaults to the target cluster. It's also the default for the conditions of the component.
+	Cluster string `yaml:"cluster"`
+
 	// DependsOn is the list of component names this component depends on
 	DependsOn []DependsSpec `yaml:"dependsOn"`
 
@@ -34,6 +236,7 @@ type Component struct {
 	Kustomization Kustomization `yaml:"kustomization"`
 
 	// Envsubst can be set to true to perform envsubst like substitutions from the --env-subst file
+	// on the kustomization and all conditions of the component
 	Envsubst bool `yaml:"envsubst"`
 
 	// ReadinessConditions specify all conditions that need to be meet so that the component is considered
@@ -43,47 +246,265 @@ type Component struct {
 	// ApplyConditions are the conditions that need to be fulfulled upfront.
 	// If the conditions are not fulfulled, the component will be skipped
 	ApplyConditions ConditionSlice `yaml:"applyConditions"`
+
+	// Outputs are read once the component is ready, and available as variables for
+	// envsubst in components that depend on this component
+	Outputs []Output `yaml:"outputs"`
+
+	// ForEach expands the component to one component per item, named <name>-<key>
+	ForEach []ForEachItem `yaml:"forEach"`
+
+	// Matrix expands the component to one component per combination of the values
+	// of all variables, named <name>-<value1>-<value2>
+	Matrix map[string][]string `yaml:"matrix"`
+
+	// InstanceOf is the name of the component this component has been expanded from
+	InstanceOf string `yaml:"-"`
+
+	// ItemVars are the variables of the forEach item or matrix combination
+	ItemVars map[string]string `yaml:"-"`
+
+	pos Position
+}
+
+// ForEachItem is an instance of a component with forEach
+type ForEachItem struct {
+
+	// Key is the suffix of the component name
+	Key string `yaml:"key"`
+
+	// Vars are available for envsubst in the component, they take precedence over all other variables
+	Vars map[string]string `yaml:"vars"`
 }
 
+// Output reads a value of a cluster object into a variable
+type Output struct {
+
+	// Name is the name of the variable
+	Name string `yaml:"name"`
+
+	// ObjectValue specifies the object and the goTemplate to get the value
+	ObjectValue ObjectValueOperant `yaml:"objectValue"`
+
+	pos Position
+}
+
+// DependsSpec references a component which needs to be ready before the component is applied.
+// It's written as the name of the component.
 type DependsSpec struct {
 	// Name of the component that we depend on
 	Name string `yaml:"name"`
 }
 
+// Conditions is a single condition, exactly one of the condition types needs to be set
 type Conditions struct {
-	Message                  string                             `yaml:"message"`
+
+	// Message is printed if the condition is not fulfilled
+	Message string `yaml:"message"`
+
+	// Cluster is the name of the cluster in the clusters map the condition is evaluated on
+	Cluster string `yaml:"cluster"`
+
+	// CustomResourceDefinition tests the existence of a CRD
 	CustomResourceDefinition *CustomResourceDefinitionCondition `yaml:"customResourceDefinition"`
-	Compare                  *CompareCondition                  `yaml:"compare"`
-	ServiceReady             *ServiceReadyCondition             `yaml:"serviceReady"`
+
+	// Compare compares two values, which may be read from objects of the cluster
+	Compare *CompareCondition `yaml:"compare"`
+
+	// ServiceReady tests that a Service has ready endpoints
+	ServiceReady *ServiceReadyCondition `yaml:"serviceReady"`
+
+	// Exec runs a local command
+	Exec *ExecCondition `yaml:"exec"`
+
+	// ServerVersion tests the version of the kubernetes API server
+	ServerVersion *ServerVersionCondition `yaml:"serverVersion"`
+
+	// Nodes tests for Ready nodes
+	Nodes *NodesCondition `yaml:"nodes"`
+
+	// DefaultStorageClass tests for a default StorageClass
+	DefaultStorageClass *DefaultStorageClassCondition `yaml:"defaultStorageClass"`
+
+	// HelmReleaseReady tests that a Flux HelmRelease is Ready
+	HelmReleaseReady *HelmReleaseReadyCondition `yaml:"helmReleaseReady"`
+
+	// FluxKustomizationReady tests that a Flux Kustomization is Ready
+	FluxKustomizationReady *FluxKustomizationReadyCondition `yaml:"fluxKustomizationReady"`
+
+	// ObjectAbsent tests that an object doesn't exist
+	ObjectAbsent *ObjectAbsentCondition `yaml:"objectAbsent"`
+
+	pos Position
 }
 
+// CompareCondition is fulfilled if both operands have the same value
 type CompareCondition struct {
+
+	// Value is the first operand
 	Value CompareOperant `yaml:"value"`
-	With  CompareOperant `yaml:"with"`
+
+	// With is the second operand
+	With CompareOperant `yaml:"with"`
 }
 
+// CompareOperant is an operand of compare, eighter objectValue or scalarValue needs to be set
 type CompareOperant struct {
+
+	// ObjectValue reads the value from an object of the cluster
 	ObjectValue *ObjectValueOperant `yaml:"objectValue"`
-	ScalarValue interface{}         `yaml:"scalarValue"`
+
+	// ScalarValue is a constant value
+	ScalarValue interface{} `yaml:"scalarValue"`
 }
 
+// ServiceReadyCondition is fulfilled if the Service has ready endpoints
 type ServiceReadyCondition struct {
 	Name      string `yaml:"name"`
 	Namespace string `yaml:"namespace"`
 }
 
+// ExecCondition runs a local command, the condition is fulfilled if it exits with code 0
+type ExecCondition struct {
+
+	// Command is the executable to run. Relative paths are resolved against the playbook directory
+	Command string `yaml:"command"`
+
+	// Args are passed to the command
+	Args []string `yaml:"args"`
+
+	// Env are additional environment variables for the command. The envsubst variables,
+	// KUBECONFIG and KUBECONTEXT are always provided
+	Env map[string]string `yaml:"env"`
+
+	// Timeout is the maximum duration of the command (like "30s"), it defaults to DefaultExecTimeout
+	Timeout string `yaml:"timeout"`
+}
+
+// ServerVersionCondition is fulfilled if the version of the kubernetes API server satisfies the constraint
+type ServerVersionCondition struct {
+
+	// Constraint is a comma separated list of comparisons, like ">= 1.24, < 1.27". A version
+	// without an operator is the minimum version, so "1.24" is equivalent to ">= 1.24"
+	Constraint VersionConstraint `yaml:"constraint"`
+}
+
+// NodesCondition is fulfilled if enough Ready nodes exist in the cluster
+type NodesCondition struct {
+
+	// Name is the name of a node that must exist and be Ready
+	Name string `yaml:"name"`
+
+	// Selector is a label selector the nodes need to match
+	Selector string `yaml:"selector"`
+
+	// MinReady is the minimal count of Ready nodes matching the selector, it defaults to 1
+	MinReady int `yaml:"minReady"`
+}
+
+// DefaultStorageClassCondition is fulfilled if the cluster has a default StorageClass
+type DefaultStorageClassCondition struct {
+
+	// Name is the optional name the default StorageClass must have
+	Name string `yaml:"name"`
+}
+
+// HelmReleaseReadyCondition is fulfilled if a Flux HelmRelease is Ready for its current generation
+type HelmReleaseReadyCondition struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace"`
+}
+
+// FluxKustomizationReadyCondition is fulfilled if a Flux Kustomization is Ready for its current generation
+type FluxKustomizationReadyCondition struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace"`
+}
+
+// ObjectAbsentCondition is fulfilled if no matching object exists.
+// Objects which are being deleted are still considered to be present.
+type ObjectAbsentCondition struct {
+	ApiVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Namespace  string `yaml:"namespace"`
+
+	// Name of the object, eighter Name or Selector needs to be set
+	Name string `yaml:"name"`
+
+	// Selector is a label selector for the objects
+	Selector string `yaml:"selector"`
+}
+
 type GoTemplateSpec string
 
+// ObjectValueOperant reads a value of an object with a goTemplate
 type ObjectValueOperant struct {
-	ApiVersion string         `yaml:"apiVersion"`
-	Kind       string         `yaml:"kind"`
-	Namespace  string         `yaml:"namespace"`
-	Name       string         `yaml:"name"`
+	ApiVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Namespace  string `yaml:"namespace"`
+	Name       string `yaml:"name"`
+
+	// GoTemplate is evaluated on the object, like "{{.status.phase}}"
 	GoTemplate GoTemplateSpec `yaml:"goTemplate"`
 }
 
 type Condition interface {
-	IsFulfilled(ctx context.Context, ka *kubeaccess.KubeAccess) (bool, error)
+	IsFulfilled(ctx context.Context, ec *EvalContext) (bool, error)
+}
+
+// EvalContext provides everything conditions need to be evaluated
+type EvalContext struct {
+	KubeAccess  *kubeaccess.KubeAccess
+	KubeConfig  string
+	KubeContext string
+
+	// Directory is the directory containing the playbook
+	Directory string
+
+	// Envs are the variables used for envsubst
+	Envs map[string]string
+
+	// Reason is the message of the last condition which was not fulfilled
+	Reason string
+
+	// Actual and Expected are the values of the last condition which compares values, like compare
+	Actual   string
+	Expected string
+
+	// Evaluated is called with the result of every condition, if it's set
+	Evaluated func(result ConditionResult)
+
+	// ClusterAccess provides the access to the clusters of the clusters map
+	ClusterAccess ClusterAccessFunc
+}
+
+// ConditionResult is the result of evaluating a condition
+type ConditionResult struct {
+
+	// Type is the condition type, like compare
+	Type string
+
+	// Cluster is the name of the cluster the condition is evaluated on, empty for the target cluster
+	Cluster string
+
+	Fulfilled bool
+
+	// Reason is the message if the condition is not fulfilled
+	Reason string
+
+	// Actual and Expected are the compared values, if the condition compares values
+	Actual   string
+	Expected string
+}
+
+// ClusterAccessFunc returns the access to a cluster of the clusters map
+type ClusterAccessFunc func(ctx context.Context, name string) (*ClusterAccess, error)
+
+// ClusterAccess is the access to a single cluster
+type ClusterAccess struct {
+	KubeAccess  *kubeaccess.KubeAccess
+	KubeConfig  string
+	KubeContext string
 }
 
 // interface implementation assertions
@@ -91,8 +512,18 @@ var _ Condition = new(CustomResourceDefinitionCondition)
 var _ Condition = new(CompareCondition)
 var _ Condition = new(Conditions)
 var _ Condition = new(ServiceReadyCondition)
+var _ Condition = new(ExecCondition)
+var _ Condition = new(ServerVersionCondition)
+var _ Condition = new(NodesCondition)
+var _ Condition = new(DefaultStorageClassCondition)
+var _ Condition = new(HelmReleaseReadyCondition)
+var _ Condition = new(FluxKustomizationReadyCondition)
+var _ Condition = new(ObjectAbsentCondition)
 
+// CustomResourceDefinitionCondition is fulfilled if the CRD exists
 type CustomResourceDefinitionCondition struct {
+
+	// Name is the name of the CRD, like innodbclusters.mysql.oracle.com
 	Name string `yaml:"name"`
 }
 
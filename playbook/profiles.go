@@ -0,0 +1,163 @@
+package playbook
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/gprossliner/kustomizepb/knownerror"
+)
+
+// ApplyProfile applies the overlay of the profile with the name to the playbook.
+// The vars of the profile become the defaults of the declared variables.
+func (pb *Playbook) ApplyProfile(name string) error {
+	profile, ok := pb.Profiles[name]
+	if !ok {
+		return knownerror.NewKnownError("Profile '%s' is not defined, available profiles: %s", name, strings.Join(sortedKeys(pb.Profiles), ", "))
+	}
+
+	for _, varName := range sortedKeys(profile.Vars) {
+		value := profile.Vars[varName]
+		declared := false
+		for i := range pb.Vars {
+			if pb.Vars[i].Name == varName {
+				pb.Vars[i].Default = &value
+				declared = true
+			}
+		}
+
+		if !declared {
+			pb.Vars = append(pb.Vars, VarDeclaration{Name: varName, Default: &value})
+		}
+	}
+
+	disabled := map[string]bool{}
+	for _, cname := range sortedKeys(profile.Components) {
+		pc := profile.Components[cname]
+		matched := false
+
+		for i := range pb.Components {
+			c := &pb.Components[i]
+			if c.Name != cname && c.InstanceOf != cname {
+				continue
+			}
+			matched = true
+
+			if pc.Disabled {
+				disabled[c.Name] = true
+				continue
+			}
+
+			// the conditions of the profile may be used by multiple instances
+			if pc.ReadinessConditions != nil {
+				c.ReadinessConditions = append(ConditionSlice{}, pc.ReadinessConditions...)
+			}
+			c.ReadinessConditions = append(c.ReadinessConditions, pc.AddReadinessConditions...)
+
+			if pc.Kustomization != nil {
+				c.Kustomization = mergePatch(c.Kustomization, pc.Kustomization)
+			}
+		}
+
+		if !matched {
+			return knownerror.NewKnownError("Component '%s' of profile '%s' is not defined", cname, name)
+		}
+	}
+
+	var components []Component
+	for _, c := range pb.Components {
+		if disabled[c.Name] {
+			continue
+		}
+
+		var dependsOn []DependsSpec
+		for _, d := range c.DependsOn {
+			if !disabled[d.Name] {
+				dependsOn = append(dependsOn, d)
+			}
+		}
+		c.DependsOn = dependsOn
+
+		components = append(components, c)
+	}
+	pb.Components = components
+
+	return nil
+}
+
+// mergePatch applies a JSON merge patch (RFC 7386) to the kustomization
+func mergePatch(k Kustomization, patch Kustomization) Kustomization {
+	res := Kustomization{}
+	for key, v := range k {
+		res[key] = v
+	}
+
+	for key, v := range patch {
+		if v == nil {
+			delete(res, key)
+		} else {
+			res[key] = mergePatchValue(res[key], v)
+		}
+	}
+
+	return res
+}
+
+// mergePatchValue merges maps recursively, all other values are replaced.
+// Nested maps are unmarshalled by yaml.v2 as map[interface{}]interface{}, but may also
+// be map[string]interface{} or Kustomization, the result is a map[interface{}]interface{}.
+func mergePatchValue(target interface{}, patch interface{}) interface{} {
+	p, ok := mapEntries(patch)
+	if !ok {
+		return patch
+	}
+
+	res := map[interface{}]interface{}{}
+	if t, ok := mapEntries(target); ok {
+		for key, v := range t {
+			res[key] = v
+		}
+	}
+
+	for key, v := range p {
+		if v == nil {
+			delete(res, key)
+		} else {
+			res[key] = mergePatchValue(res[key], v)
+		}
+	}
+
+	return res
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// mapEntries returns the entries of a map of any of the types of nested maps, like mapValue
+func mapEntries(m interface{}) (map[interface{}]interface{}, bool) {
+	switch m := m.(type) {
+	case map[interface{}]interface{}:
+		return m, true
+	case map[string]interface{}:
+		return stringMapEntries(m), true
+	case Kustomization:
+		return stringMapEntries(m), true
+	}
+
+	return nil, false
+}
+
+func stringMapEntries(m map[string]interface{}) map[interface{}]interface{} {
+	res := map[interface{}]interface{}{}
+	for k, v := range m {
+		res[k] = v
+	}
+
+	return res
+}
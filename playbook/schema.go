@@ -0,0 +1,274 @@
+package playbook
+
+//go:generate go run gen_schema_docs.go
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// SchemaVersion is the JSON Schema dialect of the generated schema
+const SchemaVersion = "http://json-schema.org/draft-07/schema#"
+
+// Schema is the subset of JSON Schema used to describe playbooks. It's generated from the
+// types of this package, and used by Decode to check playbooks before they are decoded.
+type Schema struct {
+	Schema      string             `json:"$schema,omitempty"`
+	Ref         string             `json:"$ref,omitempty"`
+	Title       string             `json:"title,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	AllOf       []*Schema          `json:"allOf,omitempty"`
+	AnyOf       []*Schema          `json:"anyOf,omitempty"`
+	OneOf       []*Schema          `json:"oneOf,omitempty"`
+
+	// AdditionalProperties is false for structs, and the schema of the values for maps
+	AdditionalProperties any `json:"additionalProperties,omitempty"`
+
+	Definitions map[string]*Schema `json:"definitions,omitempty"`
+}
+
+// schemaEnums are the allowed values of string types
+var schemaEnums = map[reflect.Type][]string{
+	reflect.TypeOf(VarType("")): {
+		string(VarTypeString), string(VarTypeInt), string(VarTypeBool), string(VarTypeIP),
+		string(VarTypeCIDR), string(VarTypeEmail), string(VarTypeURL),
+	},
+}
+
+// schemaFieldEnums are the allowed values of string fields, the apiVersion is set by the generator
+var schemaFieldEnums = map[string][]string{
+	"Playbook.Kind": {Kind},
+}
+
+// schemaStrings are the structs which are written as strings
+var schemaStrings = map[reflect.Type]bool{
+	reflect.TypeOf(DependsSpec{}): true,
+}
+
+// schemaRequired are the required fields of the structs
+var schemaRequired = map[reflect.Type][]string{
+	reflect.TypeOf(Component{}):      {"name"},
+	reflect.TypeOf(Include{}):        {"name", "path"},
+	reflect.TypeOf(ForEachItem{}):    {"key"},
+	reflect.TypeOf(VarDeclaration{}): {"name"},
+}
+
+// playbookSchemas are the schemas of the Playbook by apiVersion, they are generated once
+var playbookSchemas = generateSchemas()
+
+// JSONSchema returns the JSON Schema of playbooks of all versions which can be read. A playbook
+// needs to match the schema of its apiVersion, the versions are combined by anyOf, so playbooks
+// without apiVersion, like while they are written, match every version. The definitions of types which differ from the hub
+// version are prefixed by the version, like v1beta1.Component.
+func JSONSchema() ([]byte, error) {
+	playbookType := reflect.TypeOf(Playbook{})
+	g := &schemaGenerator{defs: map[string]*Schema{}, apiVersion: ApiVersion}
+	res := &Schema{
+		Schema: SchemaVersion,
+		Title:  Kind,
+		AnyOf:  []*Schema{g.schemaFor(playbookType, "")},
+	}
+
+	for _, version := range sortedKeys(conversions) {
+		c := conversions[version]
+		vg := &schemaGenerator{
+			defs:       g.defs,
+			apiVersion: version,
+			prefix:     version[strings.LastIndex(version, "/")+1:] + ".",
+			objects:    c.schemaObjects,
+			versioned:  map[reflect.Type]bool{},
+		}
+		containsTypes(playbookType, c.schemaObjects, vg.versioned, map[reflect.Type]bool{})
+		res.AnyOf = append(res.AnyOf, vg.schemaFor(playbookType, ""))
+	}
+
+	res.Definitions = g.defs
+	return json.MarshalIndent(res, "", "  ")
+}
+
+// generateSchemas generates the schemas of all versions which can be read, which are used to check
+// playbooks before they are converted
+func generateSchemas() map[string]*Schema {
+	res := map[string]*Schema{ApiVersion: generateSchema(ApiVersion, nil)}
+	for version, c := range conversions {
+		res[version] = generateSchema(version, c.schemaObjects)
+	}
+
+	return res
+}
+
+// generateSchema generates the schema of the version, the objects are the types which are
+// written as objects in the version
+func generateSchema(version string, objects map[reflect.Type]bool) *Schema {
+	g := &schemaGenerator{defs: map[string]*Schema{}, apiVersion: version, objects: objects}
+	g.schemaFor(reflect.TypeOf(Playbook{}), "")
+
+	// the root is the Playbook itself, and not a reference to it
+	res := *g.defs["Playbook"]
+	delete(g.defs, "Playbook")
+	res.Schema = SchemaVersion
+	res.Title = Kind
+	res.Definitions = g.defs
+
+	return &res
+}
+
+type schemaGenerator struct {
+	defs map[string]*Schema
+
+	// apiVersion is the version of the generated schema
+	apiVersion string
+
+	// objects are the types which are written as objects in this version, and as strings in the hub
+	objects map[reflect.Type]bool
+
+	// the definitions of the versioned types are prefixed, because they differ from the hub
+	prefix    string
+	versioned map[reflect.Type]bool
+}
+
+// defName returns the name of the definition of the struct t
+func (g *schemaGenerator) defName(t reflect.Type) string {
+	if g.versioned[t] {
+		return g.prefix + t.Name()
+	}
+
+	return t.Name()
+}
+
+// containsTypes adds the structs containing any of the types, including the types themselves, to res
+func containsTypes(t reflect.Type, types map[reflect.Type]bool, res map[reflect.Type]bool, visited map[reflect.Type]bool) bool {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Map:
+		return containsTypes(t.Elem(), types, res, visited)
+
+	case reflect.Struct:
+		if visited[t] {
+			return res[t]
+		}
+		visited[t] = true
+
+		contains := types[t]
+		for _, f := range yamlFields(t) {
+			if containsTypes(f.Type, types, res, visited) {
+				contains = true
+			}
+		}
+
+		if contains {
+			res[t] = true
+		}
+		return contains
+	}
+
+	return false
+}
+
+// schemaFor returns the schema of t, structs are added to the definitions and referenced.
+// The field is the Type.Field name of the struct field, if t is the type of a field.
+func (g *schemaGenerator) schemaFor(t reflect.Type, field string) *Schema {
+	if field == "Playbook.ApiVersion" {
+		return &Schema{Type: "string", Enum: []string{g.apiVersion}}
+	}
+
+	if values, ok := schemaFieldEnums[field]; ok {
+		return &Schema{Type: "string", Enum: values}
+	}
+
+	if values, ok := schemaEnums[t]; ok {
+		return &Schema{Type: "string", Enum: values}
+	}
+
+	if schemaStrings[t] && !g.objects[t] {
+		return &Schema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schemaFor(t.Elem(), field)
+
+	case reflect.Interface:
+		return &Schema{}
+
+	case reflect.String:
+		return &Schema{Type: "string"}
+
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "integer"}
+
+	case reflect.Slice:
+		return &Schema{Type: "array", Items: g.schemaFor(t.Elem(), "")}
+
+	case reflect.Map:
+		if t == reflect.TypeOf(Kustomization{}) {
+			return &Schema{Type: "object"}
+		}
+
+		return &Schema{Type: "object", AdditionalProperties: g.schemaFor(t.Elem(), "")}
+
+	case reflect.Struct:
+		name := g.defName(t)
+		ref := &Schema{Ref: "#/definitions/" + name}
+		if _, ok := g.defs[name]; ok {
+			return ref
+		}
+
+		s := &Schema{
+			Title:                t.Name(),
+			Description:          schemaDocs[t.Name()],
+			Type:                 "object",
+			Properties:           map[string]*Schema{},
+			Required:             schemaRequired[t],
+			AdditionalProperties: false,
+		}
+		g.defs[name] = s
+
+		fields := yamlFields(t)
+		for _, name := range sortedKeys(fields) {
+			f := fields[name]
+			fs := g.schemaFor(f.Type, t.Name()+"."+f.Name)
+
+			// descriptions of references are ignored by draft-07, so they are wrapped in allOf
+			description := schemaDocs[t.Name()+"."+f.Name]
+			if fs.Ref != "" && description != "" {
+				fs = &Schema{AllOf: []*Schema{fs}}
+			}
+			fs.Description = description
+			s.Properties[name] = fs
+		}
+
+		// a condition has exactly one of the condition types
+		if t == reflect.TypeOf(Conditions{}) {
+			for _, name := range conditionTypes {
+				s.OneOf = append(s.OneOf, &Schema{Required: []string{name}})
+			}
+		}
+
+		return ref
+	}
+
+	panic("no schema for type " + t.String())
+}
+
+// additional returns the schema of the values of a map, or nil if there may not be additional properties
+func (s *Schema) additional() *Schema {
+	switch a := s.AdditionalProperties.(type) {
+	case *Schema:
+		return a
+	case bool:
+		if !a {
+			return nil
+		}
+	}
+
+	return &Schema{}
+}
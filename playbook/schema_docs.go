@@ -0,0 +1,141 @@
+// Code generated by gen_schema_docs.go; DO NOT EDIT.
+
+package playbook
+
+// schemaDocs are the doc comments of the types and fields, used as descriptions of the JSON Schema
+var schemaDocs = map[string]string{
+	"Cluster":                                 "Cluster references a context of a kubeconfig",
+	"Cluster.Context":                         "Context is the name of the kubeconfig context, it defaults to the target context",
+	"Cluster.Identity":                        "Identity pins the cluster, it's verified when the cluster is used for the first time",
+	"Cluster.KubeConfig":                      "KubeConfig is the path of the kubeconfig file, it defaults to the target kubeconfig",
+	"ClusterAccess":                           "ClusterAccess is the access to a single cluster",
+	"ClusterIdentity":                         "ClusterIdentity identifies a cluster. All fields which are set need to match. The identity of the current cluster can be printed by the identity command",
+	"ClusterIdentity.ConfigMap":               "ConfigMap is a sentinel ConfigMap that needs to exist with the given labels",
+	"ClusterIdentity.KubeSystemUID":           "KubeSystemUID is the UID of the kube-system namespace",
+	"ClusterIdentity.Server":                  "Server is the URL of the API server",
+	"CompareCondition":                        "CompareCondition is fulfilled if both operands have the same value",
+	"CompareCondition.Value":                  "Value is the first operand",
+	"CompareCondition.With":                   "With is the second operand",
+	"CompareOperant":                          "CompareOperant is an operand of compare, eighter objectValue or scalarValue needs to be set",
+	"CompareOperant.ObjectValue":              "ObjectValue reads the value from an object of the cluster",
+	"CompareOperant.ScalarValue":              "ScalarValue is a constant value",
+	"Component":                               "Component is a named unit of the playbook, consisting of a kustomization and conditions",
+	"Component.ApplyConditions":               "ApplyConditions are the conditions that need to be fulfulled upfront. If the conditions are not fulfulled, the component will be skipped",
+	"Component.Cluster":                       "Cluster is the name of the cluster in the clusters map the component is applied to, it defaults to the target cluster. It's also the default for the conditions of the component.",
+	"Component.DependsOn":                     "DependsOn is the list of component names this component depends on",
+	"Component.Envsubst":                      "Envsubst can be set to true to perform envsubst like substitutions from the --env-subst file on the kustomization and all conditions of the component",
+	"Component.ForEach":                       "ForEach expands the component to one component per item, named <name>-<key>",
+	"Component.InstanceOf":                    "InstanceOf is the name of the component this component has been expanded from",
+	"Component.ItemVars":                      "ItemVars are the variables of the forEach item or matrix combination",
+	"Component.Kustomization":                 "Kustomization is the kustomization definition for the component If no kustomization is provided, the component is considered to be applied if the ReadinessConditions are meet",
+	"Component.Matrix":                        "Matrix expands the component to one component per combination of the values of all variables, named <name>-<value1>-<value2>",
+	"Component.Name":                          "Name is the mandatory name of the component",
+	"Component.Outputs":                       "Outputs are read once the component is ready, and available as variables for envsubst in components that depend on this component",
+	"Component.ReadinessConditions":           "ReadinessConditions specify all conditions that need to be meet so that the component is considered to be ready, and dependent components will be applied",
+	"ConditionResult":                         "ConditionResult is the result of evaluating a condition",
+	"ConditionResult.Actual":                  "Actual and Expected are the compared values, if the condition compares values",
+	"ConditionResult.Cluster":                 "Cluster is the name of the cluster the condition is evaluated on, empty for the target cluster",
+	"ConditionResult.Reason":                  "Reason is the message if the condition is not fulfilled",
+	"ConditionResult.Type":                    "Type is the condition type, like compare",
+	"Conditions":                              "Conditions is a single condition, exactly one of the condition types needs to be set",
+	"Conditions.Cluster":                      "Cluster is the name of the cluster in the clusters map the condition is evaluated on",
+	"Conditions.Compare":                      "Compare compares two values, which may be read from objects of the cluster",
+	"Conditions.CustomResourceDefinition":     "CustomResourceDefinition tests the existence of a CRD",
+	"Conditions.DefaultStorageClass":          "DefaultStorageClass tests for a default StorageClass",
+	"Conditions.Exec":                         "Exec runs a local command",
+	"Conditions.FluxKustomizationReady":       "FluxKustomizationReady tests that a Flux Kustomization is Ready",
+	"Conditions.HelmReleaseReady":             "HelmReleaseReady tests that a Flux HelmRelease is Ready",
+	"Conditions.Message":                      "Message is printed if the condition is not fulfilled",
+	"Conditions.Nodes":                        "Nodes tests for Ready nodes",
+	"Conditions.ObjectAbsent":                 "ObjectAbsent tests that an object doesn't exist",
+	"Conditions.ServerVersion":                "ServerVersion tests the version of the kubernetes API server",
+	"Conditions.ServiceReady":                 "ServiceReady tests that a Service has ready endpoints",
+	"ConfigMapIdentity":                       "ConfigMapIdentity is a ConfigMap identifying a cluster",
+	"CustomResourceDefinitionCondition":       "CustomResourceDefinitionCondition is fulfilled if the CRD exists",
+	"CustomResourceDefinitionCondition.Name":  "Name is the name of the CRD, like innodbclusters.mysql.oracle.com",
+	"DefaultStorageClassCondition":            "DefaultStorageClassCondition is fulfilled if the cluster has a default StorageClass",
+	"DefaultStorageClassCondition.Name":       "Name is the optional name the default StorageClass must have",
+	"DependsSpec":                             "DependsSpec references a component which needs to be ready before the component is applied. It's written as the name of the component.",
+	"DependsSpec.Name":                        "Name of the component that we depend on",
+	"EvalContext":                             "EvalContext provides everything conditions need to be evaluated",
+	"EvalContext.Actual":                      "Actual and Expected are the values of the last condition which compares values, like compare",
+	"EvalContext.ClusterAccess":               "ClusterAccess provides the access to the clusters of the clusters map",
+	"EvalContext.Directory":                   "Directory is the directory containing the playbook",
+	"EvalContext.Envs":                        "Envs are the variables used for envsubst",
+	"EvalContext.Evaluated":                   "Evaluated is called with the result of every condition, if it's set",
+	"EvalContext.Reason":                      "Reason is the message of the last condition which was not fulfilled",
+	"ExecCondition":                           "ExecCondition runs a local command, the condition is fulfilled if it exits with code 0",
+	"ExecCondition.Args":                      "Args are passed to the command",
+	"ExecCondition.Command":                   "Command is the executable to run. Relative paths are resolved against the playbook directory",
+	"ExecCondition.Env":                       "Env are additional environment variables for the command. The envsubst variables, KUBECONFIG and KUBECONTEXT are always provided",
+	"ExecCondition.Timeout":                   "Timeout is the maximum duration of the command (like \"30s\"), it defaults to DefaultExecTimeout",
+	"FluxKustomizationReadyCondition":         "FluxKustomizationReadyCondition is fulfilled if a Flux Kustomization is Ready for its current generation",
+	"ForEachItem":                             "ForEachItem is an instance of a component with forEach",
+	"ForEachItem.Key":                         "Key is the suffix of the component name",
+	"ForEachItem.Vars":                        "Vars are available for envsubst in the component, they take precedence over all other variables",
+	"HelmReleaseReadyCondition":               "HelmReleaseReadyCondition is fulfilled if a Flux HelmRelease is Ready for its current generation",
+	"Include":                                 "Include references the directory of another playbook",
+	"Include.Name":                            "Name is the prefix of the included components, like platform/cert-manager",
+	"Include.Path":                            "Path is the directory of the playbook, relative to this playbook",
+	"NamespacedName":                          "NamespacedName references an object in a namespace",
+	"NodesCondition":                          "NodesCondition is fulfilled if enough Ready nodes exist in the cluster",
+	"NodesCondition.MinReady":                 "MinReady is the minimal count of Ready nodes matching the selector, it defaults to 1",
+	"NodesCondition.Name":                     "Name is the name of a node that must exist and be Ready",
+	"NodesCondition.Selector":                 "Selector is a label selector the nodes need to match",
+	"ObjectAbsentCondition":                   "ObjectAbsentCondition is fulfilled if no matching object exists. Objects which are being deleted are still considered to be present.",
+	"ObjectAbsentCondition.Name":              "Name of the object, eighter Name or Selector needs to be set",
+	"ObjectAbsentCondition.Selector":          "Selector is a label selector for the objects",
+	"ObjectValueOperant":                      "ObjectValueOperant reads a value of an object with a goTemplate",
+	"ObjectValueOperant.GoTemplate":           "GoTemplate is evaluated on the object, like \"{{.status.phase}}\"",
+	"Output":                                  "Output reads a value of a cluster object into a variable",
+	"Output.Name":                             "Name is the name of the variable",
+	"Output.ObjectValue":                      "ObjectValue specifies the object and the goTemplate to get the value",
+	"Playbook":                                "Playbook is the content of the kustomizationplaybook.yaml file",
+	"Playbook.ApiVersion":                     "ApiVersion is the version of the playbook format",
+	"Playbook.ClusterIdentity":                "ClusterIdentity pins the cluster the playbook may be applied to",
+	"Playbook.Clusters":                       "Clusters are the clusters components and conditions can be applied to by name, besides the target cluster given on the command line",
+	"Playbook.Components":                     "Components are the units of the playbook, which are applied in the order of their dependencies",
+	"Playbook.Envsubst":                       "Envsubst can be set to true to perform envsubst on the prerequisites",
+	"Playbook.Includes":                       "Includes are other playbooks, whose components are applied before the own components",
+	"Playbook.Kind":                           "Kind is always KustomizationPlaybook",
+	"Playbook.Prerequisites":                  "Prerequisites are the conditions that need to be fulfilled before any component is applied",
+	"Playbook.Profiles":                       "Profiles are overlays of the playbook by name, which are selected by --profile",
+	"Playbook.StrictEnvsubst":                 "StrictEnvsubst fails on variables which are not defined, see IsStrictEnvsubst for the default",
+	"Playbook.Tools":                          "Tools specifies the required versions of the local tools",
+	"Playbook.VarSources":                     "VarSources read variables from Secrets and ConfigMaps in a cluster",
+	"Playbook.Vars":                           "Vars declares the variables used for envsubst",
+	"Position":                                "Position is the location of an element in a playbook file",
+	"Profile":                                 "Profile is an overlay of the playbook, like for an environment",
+	"Profile.Components":                      "Components are the overlays of the components by name. The name of a component with forEach or matrix applies to all instances.",
+	"Profile.Vars":                            "Vars set the default values of variables",
+	"ProfileComponent":                        "ProfileComponent is the overlay of a component",
+	"ProfileComponent.AddReadinessConditions": "AddReadinessConditions are added to the readinessConditions of the component",
+	"ProfileComponent.Disabled":               "Disabled components are removed, and dependencies on them are ignored",
+	"ProfileComponent.Kustomization":          "Kustomization is a JSON merge patch (RFC 7386) for the kustomization of the component",
+	"ProfileComponent.ReadinessConditions":    "ReadinessConditions replace the readinessConditions of the component, if set",
+	"Schema":                                  "Schema is the subset of JSON Schema used to describe playbooks. It's generated from the types of this package, and used by Decode to check playbooks before they are decoded.",
+	"Schema.AdditionalProperties":             "AdditionalProperties is false for structs, and the schema of the values for maps",
+	"ServerVersionCondition":                  "ServerVersionCondition is fulfilled if the version of the kubernetes API server satisfies the constraint",
+	"ServerVersionCondition.Constraint":       "Constraint is a comma separated list of comparisons, like \">= 1.24, < 1.27\". A version without an operator is the minimum version, so \"1.24\" is equivalent to \">= 1.24\"",
+	"ServiceReadyCondition":                   "ServiceReadyCondition is fulfilled if the Service has ready endpoints",
+	"Tools":                                   "Tools are the version constraints for the local binaries",
+	"Tools.Kubectl":                           "Kubectl is the version constraint for kubectl, like \">= 1.25\"",
+	"Tools.Kustomize":                         "Kustomize is the version constraint for kustomize, like \">= 5.0\"",
+	"VarDeclaration":                          "VarDeclaration declares a variable, which is validated before anything is rendered",
+	"VarDeclaration.Default":                  "Default is used if the variable is not provided",
+	"VarDeclaration.Description":              "Description is for documentation only",
+	"VarDeclaration.Name":                     "Name is the mandatory name of the variable",
+	"VarDeclaration.Pattern":                  "Pattern is a regular expression the whole value needs to match",
+	"VarDeclaration.Required":                 "Required variables need to have a non-empty value",
+	"VarDeclaration.Type":                     "Type is one of the VarType constants, it defaults to string",
+	"VarRef":                                  "VarRef is a reference to a variable in an envsubst expression",
+	"VarRef.HasDefault":                       "HasDefault is true for expressions like ${VAR:-default}, which don't need the variable",
+	"VarSource":                               "VarSource reads variables from the keys of a Secret or ConfigMap. Values from Secrets are sensitive, and never printed.",
+	"VarSource.ConfigMap":                     "ConfigMap reads the variables from a ConfigMap",
+	"VarSource.Context":                       "Context is the kubeconfig context of the cluster, it defaults to the target cluster",
+	"VarSource.Secret":                        "Secret reads the variables from a Secret",
+	"VarSource.Vars":                          "Vars maps variable names to keys, if empty every key is read as a variable",
+	"VarUsage":                                "VarUsage describes a variable referenced by the playbook",
+	"VarUsage.Components":                     "Components are the names of the components that reference the variable",
+	"VarUsage.HasDefault":                     "HasDefault is true if all references provide a default value",
+}
@@ -0,0 +1,216 @@
+package playbook
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/gprossliner/kustomizepb/knownerror"
+)
+
+// templateFuncs returns the functions available in goTemplate expressions.
+// The var function returns the envsubst variable with the given name, or an empty string.
+func templateFuncs(vars map[string]string) template.FuncMap {
+	return template.FuncMap{
+		"default":       tplDefault,
+		"empty":         tplEmpty,
+		"b64enc":        tplB64Enc,
+		"b64dec":        tplB64Dec,
+		"toJson":        tplToJson,
+		"lower":         strings.ToLower,
+		"upper":         strings.ToUpper,
+		"trim":          strings.TrimSpace,
+		"int":           tplInt,
+		"semverCompare": tplSemverCompare,
+		"dig":           tplDig,
+		"hasKey":        tplHasKey,
+		"var":           func(name string) string { return vars[name] },
+	}
+}
+
+// parse parses the template with the function library
+func (gts GoTemplateSpec) parse(vars map[string]string) (*template.Template, error) {
+	tpl, err := template.New("template").Funcs(templateFuncs(vars)).Parse(string(gts))
+	if err != nil {
+		return nil, knownerror.NewKnownError("Invalid goTemplate '%s': %s", gts, err)
+	}
+
+	return tpl, nil
+}
+
+// Validate checks the syntax of the template
+func (gts GoTemplateSpec) Validate() error {
+	_, err := gts.parse(nil)
+	return err
+}
+
+// Evaluate executes the template on obj, vars are available with the var function
+func (gts GoTemplateSpec) Evaluate(obj interface{}, vars map[string]string) (string, error) {
+	tpl, err := gts.parse(vars)
+	if err != nil {
+		return "", err
+	}
+
+	var b bytes.Buffer
+	err = tpl.Execute(&b, obj)
+	if err != nil {
+		return "", knownerror.NewKnownError("Error evaluating goTemplate '%s': %s", gts, err)
+	}
+
+	return b.String(), nil
+}
+
+// tplDefault returns def if value is empty, used like {{ .spec.x | default "y" }}
+func tplDefault(def interface{}, value ...interface{}) interface{} {
+	if len(value) == 0 || tplEmpty(value[0]) {
+		return def
+	}
+
+	return value[0]
+}
+
+// tplEmpty tests for nil, zero values and empty collections
+func tplEmpty(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return rv.IsNil()
+	default:
+		return rv.IsZero()
+	}
+}
+
+func tplB64Enc(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func tplB64Dec(s string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+func tplToJson(value interface{}) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// tplInt converts numbers and numeric strings, so they can be compared with lt, gt and the like.
+// Numbers of unstructured objects are int64 or float64.
+func tplInt(value interface{}) (int, error) {
+	switch v := value.(type) {
+	case nil:
+		return 0, nil
+	case int:
+		return v, nil
+	case int32:
+		return int(v), nil
+	case int64:
+		return int(v), nil
+	case float64:
+		return int(v), nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		return strconv.Atoi(strings.TrimSpace(v))
+	default:
+		return 0, fmt.Errorf("can't convert %T to int", value)
+	}
+}
+
+// tplSemverCompare checks a version against a VersionConstraint, like {{ semverCompare ">= 1.2" .status.version }}
+func tplSemverCompare(constraint string, v string) (bool, error) {
+	return VersionConstraint(constraint).Check(v)
+}
+
+// tplDig gets a nested value of maps, the last but one argument is the default if a key is missing,
+// like {{ dig "status" "loadBalancer" "none" . }}
+func tplDig(args ...interface{}) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, fmt.Errorf("dig needs at least one key, a default and a map")
+	}
+
+	keys, def := args[:len(args)-2], args[len(args)-2]
+	current := args[len(args)-1]
+
+	for _, k := range keys {
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("dig keys must be strings, not %T", k)
+		}
+
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return def, nil
+		}
+
+		current, ok = m[key]
+		if !ok {
+			return def, nil
+		}
+	}
+
+	return current, nil
+}
+
+func tplHasKey(m map[string]interface{}, key string) bool {
+	_, ok := m[key]
+	return ok
+}
+
+// validateTemplates checks the syntax of all goTemplates, so errors are reported before anything is applied
+func (pb *Playbook) validateTemplates() []error {
+	var errs []error
+
+	validateOperant := func(op *ObjectValueOperant, pos Position) {
+		if op == nil {
+			return
+		}
+
+		if err := op.GoTemplate.Validate(); err != nil {
+			errs = append(errs, pos.wrap(err))
+		}
+	}
+
+	validateConditions := func(cs ConditionSlice) {
+		for _, c := range cs {
+			if c.Compare != nil {
+				validateOperant(c.Compare.Value.ObjectValue, c.pos)
+				validateOperant(c.Compare.With.ObjectValue, c.pos)
+			}
+		}
+	}
+
+	validateConditions(pb.Prerequisites)
+	for _, c := range pb.Components {
+		validateConditions(c.ReadinessConditions)
+		validateConditions(c.ApplyConditions)
+
+		for i := range c.Outputs {
+			validateOperant(&c.Outputs[i].ObjectValue, c.Outputs[i].pos)
+		}
+	}
+
+	return errs
+}
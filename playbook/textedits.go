@@ -0,0 +1,155 @@
+package playbook
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gprossliner/kustomizepb/knownerror"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// textPosition is the line and column of a node, both start at 1
+type textPosition struct {
+	line, column int
+}
+
+func position(n *yaml3.Node) textPosition {
+	return textPosition{n.Line, n.Column}
+}
+
+// textEdit replaces the bytes from start to end of the text
+type textEdit struct {
+	start, end int
+	insert     string
+}
+
+// textEdits are the changes of a conversion to the original text. The positions of the nodes
+// refer to the original text, also after the nodes have been converted, so all edits are
+// applied at once. The methods do nothing on a nil *textEdits, if the text isn't needed.
+type textEdits struct {
+	data  []byte
+	edits []textEdit
+}
+
+func newTextEdits(data []byte) *textEdits {
+	return &textEdits{data: data}
+}
+
+// offset returns the byte offset of the position, columns are counted in characters
+func (e *textEdits) offset(p textPosition) int {
+	offset := 0
+	for line := 1; line < p.line; line++ {
+		i := bytes.IndexByte(e.data[offset:], '\n')
+		if i < 0 {
+			return len(e.data)
+		}
+		offset += i + 1
+	}
+
+	for column := 1; column < p.column && offset < len(e.data); column++ {
+		_, size := utf8.DecodeRune(e.data[offset:])
+		offset += size
+	}
+
+	return offset
+}
+
+// add replaces the text between the positions
+func (e *textEdits) add(from, to textPosition, insert string) {
+	if e == nil {
+		return
+	}
+
+	e.edits = append(e.edits, textEdit{start: e.offset(from), end: e.offset(to), insert: insert})
+}
+
+// replaceScalar replaces the value of the scalar, quotes are kept
+func (e *textEdits) replaceScalar(n *yaml3.Node, value string) {
+	if e == nil {
+		return
+	}
+
+	start := e.offset(position(n))
+	if n.Style&(yaml3.SingleQuotedStyle|yaml3.DoubleQuotedStyle) != 0 {
+		start++
+	}
+
+	e.edits = append(e.edits, textEdit{start: start, end: start + len(n.Value), insert: value})
+}
+
+// removeClosingBrace removes the closing brace of the flow mapping after the scalar
+func (e *textEdits) removeClosingBrace(n *yaml3.Node) error {
+	if e == nil {
+		return nil
+	}
+
+	end := e.scalarEnd(n)
+	for i := end; i < len(e.data); i++ {
+		switch e.data[i] {
+		case ' ', '\t':
+			continue
+		case '}':
+			e.edits = append(e.edits, textEdit{start: end, end: i + 1})
+			return nil
+		}
+		break
+	}
+
+	return knownerror.NewKnownError("Unable to convert the mapping at line %d, it's expected to end after '%s'", n.Line, n.Value)
+}
+
+// insertField inserts the field after the key and scalar value of a mapping, in a new line
+// for block mappings
+func (e *textEdits) insertField(key, value *yaml3.Node, flow bool, field string) {
+	if e == nil {
+		return
+	}
+
+	end := e.scalarEnd(value)
+	if flow {
+		e.edits = append(e.edits, textEdit{start: end, end: end, insert: ", " + field})
+		return
+	}
+
+	line := strings.Repeat(" ", key.Column-1) + field + "\n"
+	i := bytes.IndexByte(e.data[end:], '\n')
+	if i < 0 {
+		e.edits = append(e.edits, textEdit{start: len(e.data), end: len(e.data), insert: "\n" + line})
+		return
+	}
+
+	e.edits = append(e.edits, textEdit{start: end + i + 1, end: end + i + 1, insert: line})
+}
+
+// scalarEnd returns the offset after the scalar, including the quotes
+func (e *textEdits) scalarEnd(n *yaml3.Node) int {
+	end := e.offset(position(n)) + len(n.Value)
+	if n.Style&(yaml3.SingleQuotedStyle|yaml3.DoubleQuotedStyle) != 0 {
+		end += 2
+	}
+
+	return end
+}
+
+// apply returns the text with all edits applied
+func (e *textEdits) apply() ([]byte, error) {
+	edits := append([]textEdit{}, e.edits...)
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+
+	var b bytes.Buffer
+	prev := 0
+	for _, edit := range edits {
+		if edit.start < prev || edit.end < edit.start {
+			return nil, knownerror.NewKnownError("Unable to convert the playbook, the changes overlap at offset %d", edit.start)
+		}
+
+		b.Write(e.data[prev:edit.start])
+		b.WriteString(edit.insert)
+		prev = edit.end
+	}
+	b.Write(e.data[prev:])
+
+	return b.Bytes(), nil
+}
@@ -0,0 +1,265 @@
+package playbook
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gprossliner/kustomizepb/knownerror"
+)
+
+// conditionTypes are the yaml names of all condition types, the pointer fields of Conditions
+var conditionTypes = func() []string {
+	var res []string
+
+	t := reflect.TypeOf(Conditions{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Type.Kind() == reflect.Pointer {
+			name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			res = append(res, name)
+		}
+	}
+
+	return res
+}()
+
+// types returns the yaml names of the condition types which are set
+func (c *Conditions) types() []string {
+	var res []string
+
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		if f.Kind() == reflect.Pointer && !f.IsNil() {
+			name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+			res = append(res, name)
+		}
+	}
+
+	return res
+}
+
+// validate checks that exactly one condition type is set, and the shape of the condition
+func (c *Conditions) validate() []error {
+	types := c.types()
+	switch len(types) {
+	case 0:
+		return []error{c.pos.errorf("A condition needs to have one of %s", strings.Join(conditionTypes, ", "))}
+	case 1:
+	default:
+		return []error{c.pos.errorf("A condition must have only one of %s, not %s", strings.Join(conditionTypes, ", "), strings.Join(types, " and "))}
+	}
+
+	var errs []error
+	switch {
+	case c.Compare != nil:
+		for _, op := range []struct {
+			name    string
+			operant CompareOperant
+		}{{"value", c.Compare.Value}, {"with", c.Compare.With}} {
+			if err := op.operant.validate(); err != nil {
+				errs = append(errs, c.pos.errorf("Operant '%s' of compare: %s", op.name, err))
+			}
+		}
+
+	case c.Exec != nil:
+		if c.Exec.Command == "" {
+			errs = append(errs, c.pos.errorf("An exec condition needs to have a command"))
+		}
+
+		if c.Exec.Timeout != "" {
+			if _, err := time.ParseDuration(c.Exec.Timeout); err != nil {
+				errs = append(errs, c.pos.errorf("Invalid timeout '%s' for exec condition: %s", c.Exec.Timeout, err))
+			}
+		}
+
+	case c.ServerVersion != nil:
+		// constraints with variables are checked after envsubst
+		if !strings.Contains(string(c.ServerVersion.Constraint), "$") {
+			if err := c.ServerVersion.Constraint.Validate(); err != nil {
+				errs = append(errs, c.pos.wrap(err))
+			}
+		}
+
+	case c.ObjectAbsent != nil:
+		if c.ObjectAbsent.ApiVersion == "" || c.ObjectAbsent.Kind == "" {
+			errs = append(errs, c.pos.errorf("An objectAbsent condition needs to have apiVersion and kind"))
+		}
+
+		if (c.ObjectAbsent.Name == "") == (c.ObjectAbsent.Selector == "") {
+			errs = append(errs, c.pos.errorf("An objectAbsent condition needs to have either name or selector"))
+		}
+
+	case c.CustomResourceDefinition != nil && c.CustomResourceDefinition.Name == "",
+		c.ServiceReady != nil && c.ServiceReady.Name == "",
+		c.HelmReleaseReady != nil && c.HelmReleaseReady.Name == "",
+		c.FluxKustomizationReady != nil && c.FluxKustomizationReady.Name == "":
+		errs = append(errs, c.pos.errorf("A %s condition needs to have a name", types[0]))
+	}
+
+	return errs
+}
+
+// validate checks that exactly one of objectValue and scalarValue is set
+func (op CompareOperant) validate() error {
+	if op.ObjectValue != nil && op.ScalarValue != nil {
+		return knownerror.NewKnownError("must not have both objectValue and scalarValue")
+	}
+
+	if op.ObjectValue == nil && op.ScalarValue == nil {
+		return knownerror.NewKnownError("needs to have objectValue or scalarValue")
+	}
+
+	if op.ObjectValue != nil {
+		return op.ObjectValue.validate()
+	}
+
+	return nil
+}
+
+// validate checks that the object is fully specified
+func (ov *ObjectValueOperant) validate() error {
+	var missing []string
+	for _, f := range []struct{ name, value string }{
+		{"apiVersion", ov.ApiVersion},
+		{"kind", ov.Kind},
+		{"name", ov.Name},
+		{"goTemplate", string(ov.GoTemplate)},
+	} {
+		if f.value == "" {
+			missing = append(missing, f.name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return knownerror.NewKnownError("objectValue needs to have %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// validateConditions checks the shape of all conditions
+func (pb *Playbook) validateConditions() []error {
+	var errs []error
+
+	for _, c := range pb.Prerequisites {
+		errs = append(errs, c.validate()...)
+	}
+
+	for _, comp := range pb.Components {
+		for _, c := range comp.ReadinessConditions {
+			errs = append(errs, c.validate()...)
+		}
+
+		for _, c := range comp.ApplyConditions {
+			errs = append(errs, c.validate()...)
+		}
+	}
+
+	// components without a kustomization are valid, but an empty one is most likely a mistake
+	for i := range pb.Components {
+		c := &pb.Components[i]
+		if c.Kustomization != nil && len(c.Kustomization) == 0 {
+			errs = append(errs, c.pos.errorf("The kustomization of component '%s' is empty", c.Name))
+		}
+	}
+
+	return errs
+}
+
+// validateTools checks the version constraints of the tools
+func (pb *Playbook) validateTools() []error {
+	var errs []error
+	for _, t := range []struct {
+		name       string
+		constraint VersionConstraint
+	}{{"kustomize", pb.Tools.Kustomize}, {"kubectl", pb.Tools.Kubectl}} {
+		if err := t.constraint.Validate(); err != nil {
+			errs = append(errs, pb.positions["tools"].errorf("Tool %s: %s", t.name, err))
+		}
+	}
+
+	return errs
+}
+
+// validateComponentGraph checks for duplicate component names and dependency cycles
+func (pb *Playbook) validateComponentGraph() []error {
+	var errs []error
+
+	seen := map[string]bool{}
+	for _, c := range pb.Components {
+		if seen[c.Name] {
+			errs = append(errs, c.pos.errorf("Component '%s' is defined multiple times", c.Name))
+		}
+		seen[c.Name] = true
+	}
+
+	// depth first search, the path is the current chain of dependencies
+	const (
+		unvisited = iota
+		inProgress
+		done
+	)
+
+	state := map[string]int{}
+	var path []string
+	var visit func(c *Component)
+	visit = func(c *Component) {
+		state[c.Name] = inProgress
+		path = append(path, c.Name)
+
+		for _, d := range c.DependsOn {
+			switch state[d.Name] {
+			case inProgress:
+				start := 0
+				for i, n := range path {
+					if n == d.Name {
+						start = i
+					}
+				}
+
+				cycle := append(append([]string{}, path[start:]...), d.Name)
+				errs = append(errs, c.pos.errorf("Dependency cycle: %s", strings.Join(cycle, " -> ")))
+
+			case unvisited:
+				if dc := pb.tryFindComponent(d.Name); dc != nil {
+					visit(dc)
+				}
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[c.Name] = done
+	}
+
+	for i := range pb.Components {
+		if state[pb.Components[i].Name] == unvisited {
+			visit(&pb.Components[i])
+		}
+	}
+
+	return errs
+}
+
+// ValidatePaths checks that the local paths in the kustomizations exist, relative to the directory.
+// Paths containing variables are not checked.
+func (pb *Playbook) ValidatePaths(directory string) []error {
+	var errs []error
+
+	for _, c := range pb.Components {
+		for _, p := range c.Kustomization.localPaths() {
+			if strings.Contains(p, "$") {
+				continue
+			}
+
+			if _, err := os.Stat(filepath.Join(directory, p)); err != nil {
+				errs = append(errs, c.pos.errorf("Path '%s' of component '%s' doesn't exist", p, c.Name))
+			}
+		}
+	}
+
+	return errs
+}
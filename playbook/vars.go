@@ -0,0 +1,489 @@
+package playbook
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/drone/envsubst"
+	"github.com/drone/envsubst/parse"
+	"github.com/gprossliner/kustomizepb/knownerror"
+	"gopkg.in/yaml.v2"
+)
+
+// VarRef is a reference to a variable in an envsubst expression
+type VarRef struct {
+	Name string
+
+	// HasDefault is true for expressions like ${VAR:-default}, which don't need the variable
+	HasDefault bool
+}
+
+// VarUsage describes a variable referenced by the playbook
+type VarUsage struct {
+	Name string
+
+	// Components are the names of the components that reference the variable
+	Components []string
+
+	// HasDefault is true if all references provide a default value
+	HasDefault bool
+}
+
+// ReferencedVars returns all variables referenced by envsubst expressions in s
+func ReferencedVars(s string) ([]VarRef, error) {
+	tree, err := parse.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []VarRef
+	var walk func(n parse.Node)
+	walk = func(n parse.Node) {
+		switch n := n.(type) {
+		case *parse.ListNode:
+			for _, c := range n.Nodes {
+				walk(c)
+			}
+		case *parse.FuncNode:
+			switch n.Name {
+			case "-", "=", ":-", ":=":
+				refs = append(refs, VarRef{Name: n.Param, HasDefault: true})
+			default:
+				refs = append(refs, VarRef{Name: n.Param})
+			}
+
+			for _, a := range n.Args {
+				walk(a)
+			}
+		}
+	}
+
+	walk(tree.Root)
+	return refs, nil
+}
+
+// ReferencedVars returns the variables referenced by the kustomization
+func (k Kustomization) ReferencedVars() ([]VarRef, error) {
+	data, err := yaml.Marshal(k)
+	if err != nil {
+		return nil, err
+	}
+
+	return ReferencedVars(string(data))
+}
+
+// IsStrictEnvsubst returns if unresolved variables are an error. This is the default,
+// except for the v1beta1 apiVersion, where unresolved variables are replaced with an empty string.
+// v1beta1 playbooks are decoded with strictEnvsubst: false, if they don't set it.
+func (pb *Playbook) IsStrictEnvsubst() bool {
+	if pb.StrictEnvsubst != nil {
+		return *pb.StrictEnvsubst
+	}
+
+	return pb.ApiVersion != ApiVersionV1beta1
+}
+
+// envsubstScope is a part of the playbook envsubst is performed on
+type envsubstScope struct {
+
+	// name is the name of the component, or PrerequisitesScope
+	name string
+
+	// label describes the scope in messages
+	label string
+
+	// parts are pointers to the values which are substituted
+	parts []interface{}
+
+	// vars are the item vars of expanded components, which take precedence
+	vars map[string]string
+}
+
+// PrerequisitesScope is reported as the user of variables in prerequisites
+const PrerequisitesScope = "prerequisites"
+
+// envsubstScopes returns the prerequisites and components that have envsubst enabled
+func (pb *Playbook) envsubstScopes() []envsubstScope {
+	var res []envsubstScope
+
+	if pb.Envsubst {
+		res = append(res, envsubstScope{
+			name:  PrerequisitesScope,
+			label: PrerequisitesScope,
+			parts: []interface{}{&pb.Prerequisites},
+		})
+	}
+
+	for i := range pb.Components {
+		c := &pb.Components[i]
+		if c.Envsubst {
+			res = append(res, c.envsubstScope())
+		}
+	}
+
+	return res
+}
+
+// envsubstScope returns the scope of the component
+func (c *Component) envsubstScope() envsubstScope {
+	return envsubstScope{
+		name:  c.Name,
+		label: "component '" + c.Name + "'",
+		parts: []interface{}{&c.Kustomization, &c.ReadinessConditions, &c.ApplyConditions},
+		vars:  c.ItemVars,
+	}
+}
+
+// EnvSubst performs envsubst on the kustomization and conditions, if envsubst is enabled.
+// If strict is set, unresolved variables are an error.
+func (c *Component) EnvSubst(vars map[string]string, strict bool) error {
+	if !c.Envsubst {
+		return nil
+	}
+
+	s := c.envsubstScope()
+	if strict {
+		refs, err := s.referencedVars()
+		if err != nil {
+			return err
+		}
+
+		unresolved := unresolvedVars(refs, vars)
+		if len(unresolved) > 0 {
+			return knownerror.NewKnownError("Unresolved variables in %s: %s", s.label, strings.Join(unresolved, ", "))
+		}
+	}
+
+	return s.envSubst(vars)
+}
+
+// EnvSubstPrerequisites performs envsubst on the prerequisites, if envsubst is enabled
+func (pb *Playbook) EnvSubstPrerequisites(vars map[string]string) error {
+	scopes := pb.envsubstScopes()
+	if len(scopes) == 0 || scopes[0].name != PrerequisitesScope {
+		return nil
+	}
+
+	return scopes[0].envSubst(vars)
+}
+
+// referencedVars returns the variables referenced by all parts of the scope
+func (s envsubstScope) referencedVars() ([]VarRef, error) {
+	var refs []VarRef
+
+	for _, p := range s.parts {
+		data, err := yaml.Marshal(p)
+		if err != nil {
+			return nil, err
+		}
+
+		r, err := ReferencedVars(string(data))
+		if err != nil {
+			return nil, knownerror.NewKnownError("Invalid envsubst expression in %s: %s", s.label, err)
+		}
+
+		// item vars are always resolved
+		for _, ref := range r {
+			if _, isItemVar := s.vars[ref.Name]; !isItemVar {
+				refs = append(refs, ref)
+			}
+		}
+	}
+
+	return refs, nil
+}
+
+// envSubst performs the substitution on all parts of the scope
+func (s envsubstScope) envSubst(vars map[string]string) error {
+	lookup := func(name string) string {
+		if v, ok := s.vars[name]; ok {
+			return v
+		}
+		return vars[name]
+	}
+
+	for _, p := range s.parts {
+		data, err := yaml.Marshal(p)
+		if err != nil {
+			return err
+		}
+
+		res, err := envsubst.Eval(string(data), lookup)
+		if err != nil {
+			return knownerror.NewKnownError("Invalid envsubst expression in %s: %s", s.label, err)
+		}
+
+		// reset the value, because unmarshal merges maps
+		v := reflect.ValueOf(p).Elem()
+		v.Set(reflect.Zero(v.Type()))
+
+		err = yaml.Unmarshal([]byte(res), p)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReferencedVars returns all variables referenced by the prerequisites and components that
+// have envsubst enabled, sorted by name
+func (pb *Playbook) ReferencedVars() ([]VarUsage, error) {
+	usages := map[string]*VarUsage{}
+
+	for _, s := range pb.envsubstScopes() {
+		refs, err := s.referencedVars()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, ref := range refs {
+			u, ok := usages[ref.Name]
+			if !ok {
+				u = &VarUsage{Name: ref.Name, HasDefault: true}
+				usages[ref.Name] = u
+			}
+
+			if len(u.Components) == 0 || u.Components[len(u.Components)-1] != s.name {
+				u.Components = append(u.Components, s.name)
+			}
+
+			u.HasDefault = u.HasDefault && ref.HasDefault
+		}
+	}
+
+	var res []VarUsage
+	for _, u := range usages {
+		res = append(res, *u)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res, nil
+}
+
+// unresolvedVars returns the names of all variables in refs without a default, which are not in vars
+func unresolvedVars(refs []VarRef, vars map[string]string) []string {
+	var res []string
+	seen := map[string]bool{}
+
+	for _, ref := range refs {
+		if ref.HasDefault || seen[ref.Name] {
+			continue
+		}
+
+		if _, ok := vars[ref.Name]; !ok {
+			res = append(res, ref.Name)
+			seen[ref.Name] = true
+		}
+	}
+
+	return res
+}
+
+// CheckUnresolvedVars returns an error listing all unresolved variables per component,
+// if strict envsubst is enabled. Outputs of components are considered to be resolved.
+func (pb *Playbook) CheckUnresolvedVars(vars map[string]string) error {
+	if !pb.IsStrictEnvsubst() {
+		return nil
+	}
+
+	resolved := map[string]string{}
+	for k, v := range vars {
+		resolved[k] = v
+	}
+
+	for name := range pb.OutputProducers() {
+		resolved[name] = ""
+	}
+	vars = resolved
+
+	var msgs []string
+
+	for _, s := range pb.envsubstScopes() {
+		refs, err := s.referencedVars()
+		if err != nil {
+			return err
+		}
+
+		unresolved := unresolvedVars(refs, vars)
+		if len(unresolved) > 0 {
+			msgs = append(msgs, s.label+": "+strings.Join(unresolved, ", "))
+		}
+	}
+
+	if len(msgs) > 0 {
+		return knownerror.NewKnownError("Unresolved variables in %s", strings.Join(msgs, "; "))
+	}
+
+	return nil
+}
+
+// varTypes are the supported types, the empty type is string
+var varTypes = map[VarType]bool{
+	"":            true,
+	VarTypeString: true,
+	VarTypeInt:    true,
+	VarTypeBool:   true,
+	VarTypeIP:     true,
+	VarTypeCIDR:   true,
+	VarTypeEmail:  true,
+	VarTypeURL:    true,
+}
+
+// validateValue checks if value is valid for the type
+func (vt VarType) validateValue(value string) error {
+	var err error
+
+	switch vt {
+	case "", VarTypeString:
+	case VarTypeInt:
+		_, err = strconv.Atoi(value)
+	case VarTypeBool:
+		_, err = strconv.ParseBool(value)
+	case VarTypeIP:
+		if net.ParseIP(value) == nil {
+			err = fmt.Errorf("not an IP address")
+		}
+	case VarTypeCIDR:
+		_, _, err = net.ParseCIDR(value)
+	case VarTypeEmail:
+		var addr *mail.Address
+		addr, err = mail.ParseAddress(value)
+		if err == nil && addr.Address != value {
+			err = fmt.Errorf("not a plain email address")
+		}
+	case VarTypeURL:
+		var u *url.URL
+		u, err = url.Parse(value)
+		if err == nil && (u.Scheme == "" || u.Host == "") {
+			err = fmt.Errorf("not an absolute URL")
+		}
+	default:
+		err = fmt.Errorf("unknown type '%s'", vt)
+	}
+
+	return err
+}
+
+// validateDeclarations checks the vars section of the playbook
+func (pb *Playbook) validateDeclarations() []error {
+	var errs []error
+	names := map[string]bool{}
+
+	for _, d := range pb.Vars {
+		if d.Name == "" {
+			errs = append(errs, d.pos.errorf("A variable needs to have a name"))
+			continue
+		}
+
+		if names[d.Name] {
+			errs = append(errs, d.pos.errorf("Variable '%s' is declared multiple times", d.Name))
+		}
+		names[d.Name] = true
+
+		if !varTypes[d.Type] {
+			errs = append(errs, d.pos.errorf("Variable '%s' has an unknown type '%s'", d.Name, d.Type))
+			continue
+		}
+
+		if d.Pattern != "" {
+			if _, err := regexp.Compile(d.Pattern); err != nil {
+				errs = append(errs, d.pos.errorf("Variable '%s' has an invalid pattern: %s", d.Name, err))
+				continue
+			}
+		}
+
+		if d.Default != nil {
+			if err := d.validate(*d.Default); err != nil {
+				errs = append(errs, d.pos.errorf("Default of variable '%s' is invalid: %s", d.Name, err))
+			}
+		}
+	}
+
+	for _, vs := range pb.VarSources {
+		err := vs.validate()
+		if err != nil {
+			errs = append(errs, vs.pos.wrap(err))
+		}
+	}
+
+	return errs
+}
+
+// validate checks that eighter secret or configMap is set
+func (vs *VarSource) validate() error {
+	ref := vs.Secret
+	if ref == nil {
+		ref = vs.ConfigMap
+	} else if vs.ConfigMap != nil {
+		return knownerror.NewKnownError("A varSource must not have both secret and configMap")
+	}
+
+	if ref == nil {
+		return knownerror.NewKnownError("A varSource needs to have secret or configMap")
+	}
+
+	if ref.Name == "" {
+		return knownerror.NewKnownError("A varSource needs to have a name")
+	}
+
+	return nil
+}
+
+// validate checks a non-empty value against the type and pattern
+func (d *VarDeclaration) validate(value string) error {
+	if value == "" {
+		return nil
+	}
+
+	if err := d.Type.validateValue(value); err != nil {
+		return fmt.Errorf("'%s' is not a valid %s: %s", value, d.Type, err)
+	}
+
+	if d.Pattern != "" {
+		re := regexp.MustCompile("^(?:" + d.Pattern + ")$")
+		if !re.MatchString(value) {
+			return fmt.Errorf("'%s' doesn't match the pattern '%s'", value, d.Pattern)
+		}
+	}
+
+	return nil
+}
+
+// ResolveVars applies the defaults of the declared variables to values, and validates them.
+// The returned map contains all values, values itself is not modified.
+func (pb *Playbook) ResolveVars(values map[string]string) (map[string]string, []error) {
+	res := map[string]string{}
+	for k, v := range values {
+		res[k] = v
+	}
+
+	var errs []error
+	for _, d := range pb.Vars {
+		v, ok := res[d.Name]
+		if !ok && d.Default != nil {
+			v = *d.Default
+			res[d.Name] = v
+		}
+
+		if v == "" {
+			if d.Required {
+				errs = append(errs, knownerror.NewKnownError("Variable '%s' is required", d.Name))
+			}
+			continue
+		}
+
+		if err := d.validate(v); err != nil {
+			errs = append(errs, knownerror.NewKnownError("Variable '%s' is invalid: %s", d.Name, err))
+		}
+	}
+
+	return res, errs
+}
@@ -0,0 +1,98 @@
+package playbook
+
+import (
+	"strings"
+
+	"github.com/gprossliner/kustomizepb/knownerror"
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// VersionConstraint is a comma separated list of version comparisons, which all need to match,
+// like ">= 1.24, < 1.27". Supported operators are =, !=, >, >=, < and <=.
+// A version without an operator is equivalent to ">=".
+type VersionConstraint string
+
+var versionOperators = []string{">=", "<=", "!=", ">", "<", "="}
+
+// versionComparison is a single comparison of a VersionConstraint
+type versionComparison struct {
+	op      string
+	version *version.Version
+}
+
+// parse returns the comparisons of the constraint
+func (vc VersionConstraint) parse() ([]versionComparison, error) {
+	var res []versionComparison
+	for _, part := range strings.Split(string(vc), ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		op := ">="
+		for _, o := range versionOperators {
+			if strings.HasPrefix(part, o) {
+				op = o
+				part = strings.TrimSpace(strings.TrimPrefix(part, o))
+				break
+			}
+		}
+
+		expected, err := version.ParseGeneric(part)
+		if err != nil {
+			return nil, knownerror.NewKnownError("Invalid version constraint '%s': %s", vc, err)
+		}
+
+		res = append(res, versionComparison{op: op, version: expected})
+	}
+
+	return res, nil
+}
+
+// Validate checks the syntax of the constraint
+func (vc VersionConstraint) Validate() error {
+	_, err := vc.parse()
+	return err
+}
+
+// Check tests if the given version satisfies all comparisons of the constraint
+func (vc VersionConstraint) Check(v string) (bool, error) {
+	actual, err := version.ParseGeneric(v)
+	if err != nil {
+		return false, knownerror.NewKnownError("Unable to parse version '%s': %s", v, err)
+	}
+
+	comparisons, err := vc.parse()
+	if err != nil {
+		return false, err
+	}
+
+	for _, c := range comparisons {
+		cmp, err := actual.Compare(c.version.String())
+		if err != nil {
+			return false, err
+		}
+
+		var ok bool
+		switch c.op {
+		case "=":
+			ok = cmp == 0
+		case "!=":
+			ok = cmp != 0
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		}
+
+		if !ok {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
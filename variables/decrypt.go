@@ -0,0 +1,174 @@
+package variables
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/gprossliner/kustomizepb/knownerror"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// EnvAgeKey and EnvAgeKeyFile provide the age identities, like for SOPS
+	EnvAgeKey     = "SOPS_AGE_KEY"
+	EnvAgeKeyFile = "SOPS_AGE_KEY_FILE"
+
+	// SopsBinary is executed to decrypt SOPS files
+	SopsBinary = "sops"
+
+	ageHeader      = "age-encryption.org/v1"
+	ageArmorHeader = "-----BEGIN AGE ENCRYPTED FILE-----"
+)
+
+var sopsMarker = regexp.MustCompile(`(?m)^(sops_mac=|sops:)`)
+
+// readEnvFile reads a dotenv or YAML file, which may be encrypted by age or SOPS.
+// Decryption is done in memory, the decrypted content is never written to disk.
+// Encrypted is true if the file was encrypted, so the values should be sensitive.
+func readEnvFile(path string, ageKeyFile string) (values map[string]string, encrypted bool, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, false, knownerror.NewKnownError("envfile %s doesn't exist", path)
+		}
+		return nil, false, err
+	}
+
+	format := envFileFormat(path)
+
+	if isAgeEncrypted(data) {
+		data, err = ageDecrypt(data, ageKeyFile)
+		if err != nil {
+			return nil, false, knownerror.NewKnownError("Unable to decrypt envfile %s: %s", path, err)
+		}
+		encrypted = true
+	} else if sopsMarker.Match(data) {
+		data, err = sopsDecrypt(path, format, ageKeyFile)
+		if err != nil {
+			return nil, false, knownerror.NewKnownError("Unable to decrypt envfile %s with sops: %s", path, err)
+		}
+
+		// sops is asked to output dotenv
+		format = "dotenv"
+		encrypted = true
+	}
+
+	if format == "yaml" {
+		values, err = parseYamlEnv(data)
+	} else {
+		values, err = godotenv.Unmarshal(string(data))
+	}
+
+	if err != nil {
+		return nil, false, knownerror.NewKnownError("Unable to parse envfile %s: %s", path, err)
+	}
+
+	return values, encrypted, nil
+}
+
+// envFileFormat returns yaml or dotenv based on the extension, ignoring a .age extension
+func envFileFormat(path string) string {
+	ext := filepath.Ext(strings.TrimSuffix(path, ".age"))
+	if ext == ".yaml" || ext == ".yml" {
+		return "yaml"
+	}
+
+	return "dotenv"
+}
+
+func isAgeEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(ageHeader)) || bytes.HasPrefix(bytes.TrimSpace(data), []byte(ageArmorHeader))
+}
+
+// ageIdentities reads the identities from the key file, or the EnvAgeKey environment variable
+func ageIdentities(ageKeyFile string) ([]age.Identity, error) {
+	if ageKeyFile == "" {
+		ageKeyFile = os.Getenv(EnvAgeKeyFile)
+	}
+
+	var keys io.Reader
+	if ageKeyFile != "" {
+		data, err := os.ReadFile(ageKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = bytes.NewReader(data)
+	} else if key := os.Getenv(EnvAgeKey); key != "" {
+		keys = strings.NewReader(key)
+	} else {
+		return nil, fmt.Errorf("no age key provided, use --age-key-file, %s or %s", EnvAgeKeyFile, EnvAgeKey)
+	}
+
+	return age.ParseIdentities(keys)
+}
+
+func ageDecrypt(data []byte, ageKeyFile string) ([]byte, error) {
+	identities, err := ageIdentities(ageKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var src io.Reader = bytes.NewReader(data)
+	if !bytes.HasPrefix(data, []byte(ageHeader)) {
+		src = armor.NewReader(bufio.NewReader(src))
+	}
+
+	r, err := age.Decrypt(src, identities...)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(r)
+}
+
+// sopsDecrypt executes sops, which writes the decrypted content to stdout
+func sopsDecrypt(path string, format string, ageKeyFile string) ([]byte, error) {
+	cmd := exec.Command(SopsBinary, "--decrypt", "--input-type", format, "--output-type", "dotenv", path)
+	cmd.Env = os.Environ()
+	if ageKeyFile != "" {
+		cmd.Env = append(cmd.Env, EnvAgeKeyFile+"="+ageKeyFile)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return out, nil
+}
+
+// parseYamlEnv parses a flat YAML map of variables
+func parseYamlEnv(data []byte) (map[string]string, error) {
+	m := map[string]interface{}{}
+	err := yaml.Unmarshal(data, &m)
+	if err != nil {
+		return nil, err
+	}
+
+	res := map[string]string{}
+	for k, v := range m {
+		switch v.(type) {
+		case map[interface{}]interface{}, []interface{}:
+			return nil, fmt.Errorf("value of '%s' must be a scalar", k)
+		case nil:
+			res[k] = ""
+		default:
+			res[k] = fmt.Sprint(v)
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,247 @@
+package variables
+
+import (
+	"context"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gprossliner/kustomizepb/knownerror"
+	"github.com/gprossliner/kustomizepb/kubeaccess"
+	"github.com/gprossliner/kustomizepb/playbook"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	// SourceEnvironment is the source of variables from the process environment
+	SourceEnvironment = "environment"
+
+	// SourceSet is the source of variables from --set flags
+	SourceSet = "--set"
+
+	// SourceDefault is the source of defaults declared by the playbook
+	SourceDefault = "default"
+
+	// Redacted is printed instead of the value of sensitive variables
+	Redacted = "<redacted>"
+)
+
+// sensitiveName matches the names of variables which are considered to be sensitive
+var sensitiveName = regexp.MustCompile(`(?i)(PASSWORD|SECRET|TOKEN|KEY|CREDENTIAL)`)
+
+// Variable is a variable for envsubst, with the source it was read from
+type Variable struct {
+	Name   string
+	Value  string
+	Source string
+
+	// Sensitive variables are never printed
+	Sensitive bool
+}
+
+// Set is the effective set of variables by name
+type Set map[string]Variable
+
+// KubeAccessFunc returns the KubeAccess for a kubeconfig context, the empty context is the target cluster
+type KubeAccessFunc func(kubecontext string) (*kubeaccess.KubeAccess, error)
+
+// Options specify the sources of variables. The precedence from lowest to highest is:
+//  1. EnvFiles, in the given order. They may be encrypted by SOPS or age, and are decrypted in memory
+//  2. Sources (Secrets and ConfigMaps), in the given order
+//  3. The process environment, for all Referenced variables and variables defined in EnvFiles
+//  4. Sets (KEY=VALUE)
+type Options struct {
+	EnvFiles   []string
+	Sources    []playbook.VarSource
+	Sets       []string
+	Referenced []string
+
+	// KubeAccess is used to read the Sources
+	KubeAccess KubeAccessFunc
+
+	// AgeKeyFile contains the age identities to decrypt EnvFiles
+	AgeKeyFile string
+}
+
+// Load creates the effective variable set
+func Load(ctx context.Context, options Options) (Set, error) {
+	set := Set{}
+
+	for _, f := range options.EnvFiles {
+		envMap, encrypted, err := readEnvFile(f, options.AgeKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		for k, v := range envMap {
+			set.add(k, v, f, encrypted)
+		}
+	}
+
+	for _, src := range options.Sources {
+		err := set.addSource(ctx, src, options.KubeAccess)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the process environment only overrides known variables, so it doesn't flood the set
+	names := append([]string{}, options.Referenced...)
+	for k := range set {
+		names = append(names, k)
+	}
+
+	for _, n := range names {
+		if v, ok := os.LookupEnv(n); ok {
+			set.Add(n, v, SourceEnvironment)
+		}
+	}
+
+	for _, s := range options.Sets {
+		k, v, ok := strings.Cut(s, "=")
+		if !ok || k == "" {
+			return nil, knownerror.NewKnownError("Invalid --set '%s', must be KEY=VALUE", s)
+		}
+
+		set.Add(k, v, SourceSet)
+	}
+
+	return set, nil
+}
+
+// addSource adds the variables of a Secret or ConfigMap
+func (s Set) addSource(ctx context.Context, src playbook.VarSource, kubeAccess KubeAccessFunc) error {
+	ka, err := kubeAccess(src.Context)
+	if err != nil {
+		return err
+	}
+
+	kind, ref, get := "Secret", src.Secret, ka.GetSecretData
+	if ref == nil {
+		kind, ref, get = "ConfigMap", src.ConfigMap, ka.GetConfigMapData
+	}
+
+	source := SourceName(src)
+
+	data, err := get(ctx, ref.Namespace, ref.Name)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			return knownerror.NewKnownError("%s %s not found", kind, source)
+		}
+		return err
+	}
+
+	vars := src.Vars
+	if len(vars) == 0 {
+		vars = map[string]string{}
+		for k := range data {
+			vars[k] = k
+		}
+	}
+
+	for name, key := range vars {
+		v, ok := data[key]
+		if !ok {
+			return knownerror.NewKnownError("Key '%s' for variable '%s' not found in %s %s", key, name, kind, source)
+		}
+
+		s.add(name, v, source, kind == "Secret")
+	}
+
+	return nil
+}
+
+// SourceName describes the source like "secret:namespace/name@context"
+func SourceName(src playbook.VarSource) string {
+	kind, ref := "secret", src.Secret
+	if ref == nil {
+		kind, ref = "configMap", src.ConfigMap
+	}
+
+	res := kind + ":" + ref.Namespace + "/" + ref.Name
+	if src.Context != "" {
+		res += "@" + src.Context
+	}
+
+	return res
+}
+
+// ParseSource parses a source given as flag like "namespace/name@context", where the context is optional.
+// Secret specifies if the source is a Secret or a ConfigMap.
+func ParseSource(value string, secret bool) (playbook.VarSource, error) {
+	src := playbook.VarSource{}
+
+	ref, kubecontext, _ := strings.Cut(value, "@")
+	namespace, name, ok := strings.Cut(ref, "/")
+	if !ok || namespace == "" || name == "" {
+		return src, knownerror.NewKnownError("Invalid source '%s', must be namespace/name[@context]", value)
+	}
+
+	nn := &playbook.NamespacedName{Namespace: namespace, Name: name}
+	if secret {
+		src.Secret = nn
+	} else {
+		src.ConfigMap = nn
+	}
+	src.Context = kubecontext
+
+	return src, nil
+}
+
+// Add sets the variable, overriding a variable with the same name
+func (s Set) Add(name, value, source string) {
+	s.add(name, value, source, false)
+}
+
+// add sets the variable, which is sensitive if it's name looks like it, or sensitive is set
+func (s Set) add(name, value, source string, sensitive bool) {
+	s[name] = Variable{
+		Name:      name,
+		Value:     value,
+		Source:    source,
+		Sensitive: sensitive || sensitiveName.MatchString(name),
+	}
+}
+
+// SensitiveNames returns the names of all sensitive variables
+func (s Set) SensitiveNames() map[string]bool {
+	res := map[string]bool{}
+	for k, v := range s {
+		if v.Sensitive {
+			res[k] = true
+		}
+	}
+
+	return res
+}
+
+// Values returns the values by name, as used for envsubst
+func (s Set) Values() map[string]string {
+	res := map[string]string{}
+	for k, v := range s {
+		res[k] = v.Value
+	}
+
+	return res
+}
+
+// Sorted returns all variables sorted by name
+func (s Set) Sorted() []Variable {
+	var res []Variable
+	for _, v := range s {
+		res = append(res, v)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// Display returns the value, or Redacted for sensitive variables
+func (v Variable) Display() string {
+	if v.Sensitive {
+		return Redacted
+	}
+
+	return v.Value
+}
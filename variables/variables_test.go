@@ -0,0 +1,154 @@
+package variables
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeEnvFile(t *testing.T, name string, content string) string {
+	f := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(f, []byte(content), 0600)
+	assert.NoError(t, err)
+	return f
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	base := writeEnvFile(t, "base.env", "A=base\nB=base\nC=base\nD=base\n")
+	prod := writeEnvFile(t, "prod.env", "B=prod\nC=prod\n")
+
+	t.Setenv("C", "environment")
+	t.Setenv("E", "environment")
+	t.Setenv("NOTREFERENCED", "environment")
+
+	set, err := Load(context.Background(), Options{
+		EnvFiles:   []string{base, prod},
+		Sets:       []string{"D=set", "F=set=with=equals"},
+		Referenced: []string{"E"},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, map[string]string{
+		"A": "base",
+		"B": "prod",
+		"C": "environment",
+		"D": "set",
+		"E": "environment",
+		"F": "set=with=equals",
+	}, set.Values())
+
+	assert.Equal(t, base, set["A"].Source)
+	assert.Equal(t, prod, set["B"].Source)
+	assert.Equal(t, SourceEnvironment, set["C"].Source)
+	assert.Equal(t, SourceSet, set["D"].Source)
+}
+
+func TestLoad_Errors(t *testing.T) {
+	_, err := Load(context.Background(), Options{EnvFiles: []string{filepath.Join(t.TempDir(), "doesnotexist.env")}})
+	assert.Error(t, err)
+
+	_, err = Load(context.Background(), Options{Sets: []string{"NOVALUE"}})
+	assert.Error(t, err)
+}
+
+func TestSensitive(t *testing.T) {
+	set := Set{}
+	set.Add("CFG_INNODB_ROOTPASSWORD", "verysecret", SourceSet)
+	set.Add("CFG_IP_INGRESS", "10.11.1.22", SourceSet)
+
+	assert.Equal(t, Redacted, set["CFG_INNODB_ROOTPASSWORD"].Display())
+	assert.Equal(t, "10.11.1.22", set["CFG_IP_INGRESS"].Display())
+}
+
+func TestParseSource(t *testing.T) {
+	src, err := ParseSource("db/innodb-passwords", true)
+	assert.NoError(t, err)
+	assert.Equal(t, "db", src.Secret.Namespace)
+	assert.Equal(t, "innodb-passwords", src.Secret.Name)
+	assert.Nil(t, src.ConfigMap)
+	assert.Equal(t, "secret:db/innodb-passwords", SourceName(src))
+
+	src, err = ParseSource("default/settings@management", false)
+	assert.NoError(t, err)
+	assert.Equal(t, "settings", src.ConfigMap.Name)
+	assert.Equal(t, "management", src.Context)
+	assert.Equal(t, "configMap:default/settings@management", SourceName(src))
+
+	_, err = ParseSource("settings", false)
+	assert.Error(t, err)
+}
+
+func ageEncrypt(t *testing.T, recipient age.Recipient, content string, armored bool) []byte {
+	var b bytes.Buffer
+	var out io.Writer = &b
+
+	var aw io.WriteCloser
+	if armored {
+		aw = armor.NewWriter(&b)
+		out = aw
+	}
+
+	w, err := age.Encrypt(out, recipient)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte(content))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	if aw != nil {
+		assert.NoError(t, aw.Close())
+	}
+
+	return b.Bytes()
+}
+
+func TestLoad_AgeEncrypted(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	assert.NoError(t, err)
+
+	keyFile := writeEnvFile(t, "keys.txt", identity.String()+"\n")
+
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, "secrets.env.age")
+	err = os.WriteFile(envFile, ageEncrypt(t, identity.Recipient(), "CFG_DB_HOST=db\n", false), 0600)
+	assert.NoError(t, err)
+
+	yamlFile := filepath.Join(dir, "secrets.yaml.age")
+	err = os.WriteFile(yamlFile, ageEncrypt(t, identity.Recipient(), "CFG_REPLICAS: 3\n", true), 0600)
+	assert.NoError(t, err)
+
+	set, err := Load(context.Background(), Options{EnvFiles: []string{envFile, yamlFile}, AgeKeyFile: keyFile})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"CFG_DB_HOST": "db", "CFG_REPLICAS": "3"}, set.Values())
+
+	// values of encrypted files are sensitive
+	assert.True(t, set["CFG_DB_HOST"].Sensitive)
+	assert.Equal(t, map[string]bool{"CFG_DB_HOST": true, "CFG_REPLICAS": true}, set.SensitiveNames())
+
+	// the key can be provided by the environment
+	t.Setenv(EnvAgeKey, identity.String())
+	set, err = Load(context.Background(), Options{EnvFiles: []string{envFile}})
+	assert.NoError(t, err)
+	assert.Equal(t, "db", set["CFG_DB_HOST"].Value)
+
+	// without a key
+	t.Setenv(EnvAgeKey, "")
+	_, err = Load(context.Background(), Options{EnvFiles: []string{envFile}})
+	assert.Error(t, err)
+	assert.Regexp(t, "no age key", err.Error())
+}
+
+func TestParseYamlEnv(t *testing.T) {
+	values, err := parseYamlEnv([]byte("A: a\nB: 1\nC:\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"A": "a", "B": "1", "C": ""}, values)
+
+	_, err = parseYamlEnv([]byte("A:\n  nested: true\n"))
+	assert.Error(t, err)
+}